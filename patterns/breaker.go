@@ -0,0 +1,98 @@
+package patterns
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for requests short circuited by an open circuit breaker
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops sending requests to a failing upstream.  The circuit opens after threshold consecutive failures,
+// a failure being a transport error or a 5xx response, and requests fail fast with ErrCircuitOpen for the cooldown.
+// After the cooldown a single trial request is let through, the circuit closes if it succeeds and opens again for
+// another cooldown if it fails.  The breaker is safe to share across goroutines.
+func CircuitBreaker(threshold int, cooldown time.Duration) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return &breaker{
+				next:      next,
+				threshold: threshold,
+				cooldown:  cooldown,
+			}
+		})
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type breaker struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // consecutive failures while closed
+	openedAt time.Time // when the circuit last opened
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !b.allow() {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := b.next.RoundTrip(req)
+	b.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+
+	return resp, err
+}
+
+// allow reports whether a request may be sent, moving an open circuit to half open once the cooldown has passed
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		// let this request through as the trial, everyone else waits for its outcome
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the outcome of a request
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,119 @@
+package patterns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+
+	var (
+		failing int32 = 1
+		hits    int64
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cl := NewClientWrapper(CircuitBreaker(3, cooldown))
+	send := func() (int, error) {
+		resp, err := cl.Get(context.Background(), srv.URL)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		return resp.StatusCode, nil
+	}
+
+	steps := []struct {
+		name     string
+		wait     time.Duration // sleep before the request
+		recover  bool          // upstream recovered before the request
+		wantCode int
+		wantErr  error
+		wantHits int64 // requests that reached the upstream so far
+	}{
+		{name: "first failure", wantCode: 503, wantHits: 1},
+		{name: "second failure", wantCode: 503, wantHits: 2},
+		{name: "third failure opens", wantCode: 503, wantHits: 3},
+		{name: "open", wantErr: ErrCircuitOpen, wantHits: 3},
+		{name: "failed trial reopens", wait: cooldown, wantCode: 503, wantHits: 4},
+		{name: "open again", wantErr: ErrCircuitOpen, wantHits: 4},
+		{name: "successful trial closes", wait: cooldown, recover: true, wantCode: 200, wantHits: 5},
+		{name: "closed", wantCode: 200, wantHits: 6},
+	}
+
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		if step.recover {
+			atomic.StoreInt32(&failing, 0)
+		}
+
+		code, err := send()
+		if !errors.Is(err, step.wantErr) || code != step.wantCode {
+			t.Fatalf("%s: got %d, %v, want %d, %v", step.name, code, err, step.wantCode, step.wantErr)
+		}
+		if got := atomic.LoadInt64(&hits); got != step.wantHits {
+			t.Fatalf("%s: upstream hit %d times, want %d", step.name, got, step.wantHits)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	release := make(chan struct{})
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+
+	cl := NewClientWrapper(CircuitBreaker(1, time.Millisecond))
+
+	resp, err := cl.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	time.Sleep(5 * time.Millisecond)
+
+	// the trial is held up by the upstream, every other request fails fast meanwhile
+	trial := make(chan error, 1)
+	go func() {
+		resp, err := cl.Get(context.Background(), srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		trial <- err
+	}()
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&hits) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the trial request never reached the upstream")
+		}
+	}
+
+	if _, err := cl.Get(context.Background(), srv.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("request during the trial error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	close(release)
+	if err := <-trial; err != nil {
+		t.Errorf("trial error = %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package patterns
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithResponseCache caches the responses to GET requests in memory, up to maxEntries responses are kept and the least
+// recently used is evicted to make room.  A 200 response is fresh for the max-age of its Cache-Control header less its
+// Age, fresh responses are served from the cache without hitting the upstream.  A stale response with an ETag is
+// revalidated with If-None-Match, a 304 Not Modified serves the cached body as a 200 and renews its freshness, so a
+// response marked no-cache or without a max-age is still cached when it has an ETag.  Stale responses without an ETag
+// are fetched again.  Responses marked no-store and responses with a Vary header aren't cached, and requests with a
+// body, a Range header, a conditional header, or a no-cache or no-store directive of their own bypass the cache.
+func WithResponseCache(maxEntries int) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return &responseCache{
+				next:    next,
+				max:     maxEntries,
+				entries: make(map[string]*list.Element),
+				lru:     list.New(),
+			}
+		})
+	}
+}
+
+// cacheEntry is a cached response, the body is kept in full so each hit is served a fresh reader
+type cacheEntry struct {
+	key     string
+	status  int
+	proto   string
+	header  http.Header
+	body    []byte
+	expires time.Time
+	etag    string // validator sent with If-None-Match once the entry is stale, empty when the upstream sent none
+}
+
+type responseCache struct {
+	next http.RoundTripper
+	max  int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // cache key to its element in lru
+	lru     *list.List               // cached entries, most recently used first
+}
+
+func (c *responseCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !cacheableRequest(req) {
+		return c.next.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	e, cached := c.get(key)
+	if cached && time.Now().Before(e.expires) {
+		return e.response(req), nil
+	}
+
+	send := req
+	if cached && e.etag != "" {
+		send = req.Clone(req.Context())
+		send.Header.Set("If-None-Match", e.etag)
+	}
+
+	resp, err := c.next.RoundTrip(send)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached && e.etag != "" && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		e = e.revalidated(resp.Header)
+		c.put(e)
+
+		return e.response(req), nil
+	}
+
+	maxAge, ok := cacheLifetime(resp)
+	if !ok {
+		c.remove(key)
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.put(&cacheEntry{
+		key:     key,
+		status:  resp.StatusCode,
+		proto:   resp.Proto,
+		header:  resp.Header.Clone(),
+		body:    body,
+		expires: time.Now().Add(maxAge),
+		etag:    resp.Header.Get("ETag"),
+	})
+
+	return resp, nil
+}
+
+// get returns the entry cached under key, marking it as the most recently used
+func (c *responseCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+
+	return el.Value.(*cacheEntry), true
+}
+
+// put caches e, replacing any entry under the same key and evicting the least recently used entry when full
+func (c *responseCache) put(e *cacheEntry) {
+	if c.max <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops the entry cached under key, a stale entry whose response is no longer cacheable is never served again
+func (c *responseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// revalidated returns a copy of the entry renewed by a 304 response with header h, the headers sent with the 304
+// replace the cached ones and the freshness is computed again from the merged headers
+func (e *cacheEntry) revalidated(h http.Header) *cacheEntry {
+	header := e.header.Clone()
+	header.Del("Age") // the age of the cached response no longer applies once it has been revalidated
+	for k, v := range h {
+		// a 304 has no body, its framing headers don't describe the cached one
+		if k == "Content-Length" || k == "Transfer-Encoding" {
+			continue
+		}
+		header[k] = v
+	}
+
+	etag := header.Get("ETag")
+	if etag == "" {
+		etag = e.etag
+	}
+
+	return &cacheEntry{
+		key:     e.key,
+		status:  e.status,
+		proto:   e.proto,
+		header:  header,
+		body:    e.body,
+		expires: time.Now().Add(freshness(header)),
+		etag:    etag,
+	}
+}
+
+// response builds a response to req from the cached entry
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	major, minor, _ := http.ParseHTTPVersion(e.proto)
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         e.proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// cacheableRequest reports whether the response to req may be served from and stored in the cache
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || (req.Body != nil && req.Body != http.NoBody) || req.Header.Get("Range") != "" {
+		return false
+	}
+
+	// the caller is revalidating a copy of their own, the 304 it may get back is theirs to handle
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return false
+	}
+
+	directives := cacheControl(req.Header)
+	_, noCache := directives["no-cache"]
+	_, noStore := directives["no-store"]
+
+	return !noCache && !noStore
+}
+
+// cacheLifetime returns how long resp stays fresh, false when resp isn't cacheable.  A response with an ETag is cached
+// even when it is stale from the start since it can still be revalidated.
+func cacheLifetime(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Vary") != "" {
+		return 0, false
+	}
+
+	if _, ok := cacheControl(resp.Header)["no-store"]; ok {
+		return 0, false
+	}
+
+	lifetime := freshness(resp.Header)
+
+	return lifetime, lifetime > 0 || resp.Header.Get("ETag") != ""
+}
+
+// freshness returns how long a response with header h stays fresh, zero when it must be revalidated before every use
+func freshness(h http.Header) time.Duration {
+	directives := cacheControl(h)
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+
+	maxAge, err := strconv.Atoi(directives["max-age"])
+	if err != nil || maxAge <= 0 {
+		return 0
+	}
+
+	// the response may have already spent part of its lifetime in a shared cache upstream
+	age, _ := strconv.Atoi(h.Get("Age"))
+	if age >= maxAge {
+		return 0
+	}
+
+	return time.Duration(maxAge-age) * time.Second
+}
+
+// cacheControl parses the Cache-Control directives of h, directives without a value map to an empty string
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return directives
+}
@@ -0,0 +1,238 @@
+package patterns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newCacheServer starts a server answering every path with a body counting the requests it has served, the response
+// headers are taken from the query, e.g. ?Cache-Control=max-age=60.  The server is closed when the test ends.
+func newCacheServer(t *testing.T) (*httptest.Server, *int64) {
+	t.Helper()
+
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&hits, 1)
+		for k, v := range r.URL.Query() {
+			w.Header()[http.CanonicalHeaderKey(k)] = v
+		}
+		fmt.Fprintf(w, "response %d", n)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &hits
+}
+
+// fetch issues a GET to url with cl and returns the response body, header is added to the request
+func fetch(t *testing.T, cl *ClientWrapper, url string, header http.Header) string {
+	t.Helper()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	return string(b)
+}
+
+func TestWithResponseCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string      // response headers
+		header   http.Header // request headers
+		wantHits int64       // upstream requests for two GETs
+	}{
+		{name: "fresh", query: "Cache-Control=max-age=60", wantHits: 1},
+		{name: "fresh among other directives", query: "Cache-Control=public,%20max-age=60", wantHits: 1},
+		{name: "no max-age", query: "", wantHits: 2},
+		{name: "aged out upstream", query: "Cache-Control=max-age=60&Age=60", wantHits: 2},
+		{name: "no-store", query: "Cache-Control=max-age=60,%20no-store", wantHits: 2},
+		{name: "vary", query: "Cache-Control=max-age=60&Vary=Accept", wantHits: 2},
+		{
+			name:     "request no-cache",
+			query:    "Cache-Control=max-age=60",
+			header:   http.Header{"Cache-Control": {"no-cache"}},
+			wantHits: 2,
+		},
+		{
+			name:     "range request",
+			query:    "Cache-Control=max-age=60",
+			header:   http.Header{"Range": {"bytes=0-3"}},
+			wantHits: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := newCacheServer(t)
+			cl := NewClientWrapper(WithResponseCache(10))
+			url := srv.URL + "/?" + tt.query
+
+			first := fetch(t, cl, url, tt.header)
+			second := fetch(t, cl, url, tt.header)
+			if got := atomic.LoadInt64(hits); got != tt.wantHits {
+				t.Errorf("upstream served %d requests, want %d", got, tt.wantHits)
+			}
+			if cached := first == second; cached != (tt.wantHits == 1) {
+				t.Errorf("second response %q after %q, want served from the cache %t", second, first, tt.wantHits == 1)
+			}
+		})
+	}
+
+	t.Run("post", func(t *testing.T) {
+		srv, hits := newCacheServer(t)
+		cl := NewClientWrapper(WithResponseCache(10))
+
+		for i := 0; i < 2; i++ {
+			resp, err := cl.Post(context.Background(), srv.URL+"/?Cache-Control=max-age=60", "text/plain",
+				strings.NewReader("body"))
+			if err != nil {
+				t.Fatalf("Post() error = %v", err)
+			}
+			resp.Body.Close()
+		}
+		if got := atomic.LoadInt64(hits); got != 2 {
+			t.Errorf("upstream served %d requests, want every POST", got)
+		}
+	})
+
+	t.Run("lru eviction", func(t *testing.T) {
+		srv, hits := newCacheServer(t)
+		cl := NewClientWrapper(WithResponseCache(2))
+		path := func(p string) string { return srv.URL + "/" + p + "?Cache-Control=max-age=60" }
+
+		fetch(t, cl, path("a"), nil)
+		fetch(t, cl, path("b"), nil)
+		fetch(t, cl, path("a"), nil) // a becomes the most recently used
+		fetch(t, cl, path("c"), nil) // evicts b
+		if got := atomic.LoadInt64(hits); got != 3 {
+			t.Fatalf("upstream served %d requests, want 3", got)
+		}
+
+		fetch(t, cl, path("a"), nil)
+		fetch(t, cl, path("c"), nil)
+		if got := atomic.LoadInt64(hits); got != 3 {
+			t.Errorf("upstream served %d requests, want a and c still cached", got)
+		}
+		fetch(t, cl, path("b"), nil)
+		if got := atomic.LoadInt64(hits); got != 4 {
+			t.Errorf("upstream served %d requests, want b evicted", got)
+		}
+	})
+}
+
+func TestResponseCacheRevalidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		notModified string // Cache-Control sent with the 304
+		wantHits    int64  // upstream requests for three GETs
+	}{
+		{name: "revalidated every time", wantHits: 3},
+		{name: "304 renews freshness", notModified: "max-age=60", wantHits: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits, revalidations int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt64(&hits, 1)
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					atomic.AddInt64(&revalidations, 1)
+					if tt.notModified != "" {
+						w.Header().Set("Cache-Control", tt.notModified)
+					}
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Cache-Control", "no-cache")
+				fmt.Fprintf(w, "response %d", n)
+			}))
+			t.Cleanup(srv.Close)
+
+			cl := NewClientWrapper(WithResponseCache(10))
+			for i := 0; i < 3; i++ {
+				// fetch fails the test unless the 304 is served as a 200
+				if got := fetch(t, cl, srv.URL, nil); got != "response 1" {
+					t.Errorf("request %d body = %q, want the cached %q", i+1, got, "response 1")
+				}
+			}
+			if got := atomic.LoadInt64(&hits); got != tt.wantHits {
+				t.Errorf("upstream served %d requests, want %d", got, tt.wantHits)
+			}
+			if got := atomic.LoadInt64(&revalidations); got != tt.wantHits-1 {
+				t.Errorf("upstream revalidated %d times, want %d", got, tt.wantHits-1)
+			}
+		})
+	}
+
+	t.Run("changed", func(t *testing.T) {
+		var version int64 = 1
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			etag := fmt.Sprintf(`"v%d"`, atomic.LoadInt64(&version))
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			fmt.Fprintf(w, "version %d", atomic.LoadInt64(&version))
+		}))
+		t.Cleanup(srv.Close)
+
+		cl := NewClientWrapper(WithResponseCache(10))
+		fetch(t, cl, srv.URL, nil)
+		atomic.StoreInt64(&version, 2)
+		if got := fetch(t, cl, srv.URL, nil); got != "version 2" {
+			t.Errorf("body = %q, want the changed %q", got, "version 2")
+		}
+		if got := fetch(t, cl, srv.URL, nil); got != "version 2" {
+			t.Errorf("body = %q, want the changed response cached", got)
+		}
+	})
+
+	t.Run("caller revalidating", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, "body")
+		}))
+		t.Cleanup(srv.Close)
+
+		cl := NewClientWrapper(WithResponseCache(10))
+		fetch(t, cl, srv.URL, nil)
+
+		// the caller's own conditional request gets the 304 meant for their copy
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+		req.Header.Set("If-None-Match", `"v1"`)
+		resp, err := cl.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusNotModified)
+		}
+	})
+}
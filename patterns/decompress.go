@@ -0,0 +1,145 @@
+package patterns
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// AutoDecompress controls transparent decompression of responses.  Disabling it maps to Tr.DisableCompression so
+// compressed bodies are returned as is.  Enabling it also decodes gzip, deflate, and brotli responses the transport
+// leaves alone, which it does whenever the caller sets their own Accept-Encoding header.  Decompression is only installed
+// when the transport is used through the Transport client option.
+func AutoDecompress(enable bool) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.DisableCompression = !enable
+		if enable {
+			t.wrappers = append(t.wrappers, func(next http.RoundTripper) http.RoundTripper {
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					resp, err := next.RoundTrip(req)
+					if err != nil {
+						return nil, err
+					}
+					decompress(resp)
+
+					return resp, nil
+				})
+			})
+		}
+	}
+}
+
+// AcceptEncoding advertises encodings in the Accept-Encoding header of requests that don't set their own, e.g.
+// AcceptEncoding("br", "gzip").  Setting the header stops the transport from decoding gzip itself, so responses in
+// any of gzip, deflate, and brotli are decoded by the client instead, brotli being an encoding the standard library
+// doesn't handle.  Responses in other encodings are returned as is.
+func AcceptEncoding(encodings ...string) ClientOption {
+	header := strings.Join(encodings, ", ")
+
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if req.Header.Get("Accept-Encoding") == "" {
+					req = req.Clone(req.Context())
+					req.Header.Set("Accept-Encoding", header)
+				}
+
+				resp, err := next.RoundTrip(req)
+				if err != nil {
+					return nil, err
+				}
+				decompress(resp)
+
+				return resp, nil
+			})
+		})
+	}
+}
+
+// decompress replaces the body of a gzip, deflate, or brotli encoded response with one that decodes it.  Responses
+// without a body are left alone, their Content-Encoding describes a representation that was never sent.
+func decompress(resp *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if resp.Uncompressed || (encoding != "gzip" && encoding != "deflate" && encoding != "br") || bodiless(resp) {
+		return
+	}
+
+	resp.Body = &decodingBody{body: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// bodiless reports whether resp carries no body, such as a 204 No Content, a 304 Not Modified, or the response to a
+// HEAD request, decoding an empty body fails reading the compression header
+func bodiless(resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return true
+	case resp.StatusCode >= 100 && resp.StatusCode < 200:
+		return true
+	case resp.Request != nil && resp.Request.Method == http.MethodHead:
+		return true
+	}
+
+	return resp.Body == nil || resp.Body == http.NoBody || resp.ContentLength == 0
+}
+
+// decodingBody decodes a compressed body, the decoder is created on the first read so RoundTrip doesn't block reading
+// the compression header
+type decodingBody struct {
+	body     io.ReadCloser
+	encoding string
+	r        io.Reader
+	err      error
+}
+
+func (b *decodingBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		b.r, b.err = b.decoder()
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	return b.r.Read(p)
+}
+
+func (b *decodingBody) Close() error {
+	return b.body.Close()
+}
+
+// decoder returns a reader decoding the body.  "deflate" is meant to be zlib wrapped but some servers send a raw
+// deflate stream, so the zlib header is checked before picking the decoder.
+func (b *decodingBody) decoder() (io.Reader, error) {
+	switch b.encoding {
+	case "gzip":
+		return gzip.NewReader(b.body)
+	case "br":
+		return brotli.NewReader(b.body), nil
+	}
+
+	br := bufio.NewReader(b.body)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if zlibHeader(header) {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+// zlibHeader reports whether h starts with a valid zlib header, a deflate compression method and a check sum that is a
+// multiple of 31
+func zlibHeader(h []byte) bool {
+	return h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0
+}
@@ -0,0 +1,225 @@
+package patterns
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+const plaintext = "the quick brown fox jumps over the lazy dog"
+
+// encode compresses s with the Content-Encoding named by encoding, "raw-deflate" is a deflate stream without the zlib
+// wrapper some servers send
+func encode(t *testing.T, encoding, s string) []byte {
+	t.Helper()
+
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	io.WriteString(w, s)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+// newEncodingServer starts a server responding with plaintext compressed in the encoding named by the encoding query
+// parameter, it is closed when the test ends
+func newEncodingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("encoding")
+		header := encoding
+		if encoding == "raw-deflate" {
+			header = "deflate"
+		}
+		w.Header().Set("Content-Encoding", header)
+		w.Write(encode(t, encoding, plaintext))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestAutoDecompress(t *testing.T) {
+	srv := newEncodingServer(t)
+
+	tests := []struct {
+		name     string
+		enable   bool
+		encoding string
+		want     []byte
+	}{
+		{name: "gzip", enable: true, encoding: "gzip", want: []byte(plaintext)},
+		{name: "deflate", enable: true, encoding: "deflate", want: []byte(plaintext)},
+		{name: "raw deflate", enable: true, encoding: "raw-deflate", want: []byte(plaintext)},
+		{name: "disabled", encoding: "gzip", want: encode(t, "gzip", plaintext)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(Transport(NewTransportWrapper(AutoDecompress(tt.enable))))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			// a caller setting their own Accept-Encoding stops the transport from decoding gzip itself
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL+"?encoding="+tt.encoding, nil)
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+			resp, err := cl.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading the body: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if decoded := resp.Header.Get("Content-Encoding") == ""; decoded != tt.enable {
+				t.Errorf("Content-Encoding %q left on a response decoded %t", resp.Header.Get("Content-Encoding"), tt.enable)
+			}
+		})
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	srv := newEncodingServer(t)
+
+	tests := []struct {
+		name     string
+		encoding string
+	}{
+		{name: "brotli", encoding: "br"},
+		{name: "gzip", encoding: "gzip"},
+		{name: "deflate", encoding: "deflate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(AcceptEncoding("br", "gzip", "deflate"))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Get(context.Background(), srv.URL+"?encoding="+tt.encoding)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading the body: %v", err)
+			}
+			if string(got) != plaintext {
+				t.Errorf("body = %q, want the decoded %q", got, plaintext)
+			}
+			if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+				t.Errorf("Content-Encoding %q left on a decoded response", enc)
+			}
+		})
+	}
+
+	t.Run("header", func(t *testing.T) {
+		rec, rr := newRecordingServer(t)
+		cl := NewClientWrapper(AcceptEncoding("br", "gzip"))
+
+		get(t, cl, rec.URL)
+		if got := rr.request().Header.Get("Accept-Encoding"); got != "br, gzip" {
+			t.Errorf("Accept-Encoding = %q, want %q", got, "br, gzip")
+		}
+
+		// the caller's own header is left alone
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, rec.URL, nil)
+		req.Header.Set("Accept-Encoding", "identity")
+		resp, err := cl.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		resp.Body.Close()
+		if got := rr.request().Header.Get("Accept-Encoding"); got != "identity" {
+			t.Errorf("Accept-Encoding = %q, want the caller's %q", got, "identity")
+		}
+	})
+}
+
+func TestDecompressBodiless(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", r.URL.Query().Get("encoding"))
+		switch r.URL.Path {
+		case "/no-content":
+			w.WriteHeader(http.StatusNoContent)
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("Content-Length", "0")
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	clients := []struct {
+		name string
+		cl   *ClientWrapper
+	}{
+		{name: "AcceptEncoding", cl: NewClientWrapper(AcceptEncoding("br", "gzip", "deflate"))},
+		{name: "AutoDecompress", cl: NewClientWrapper(Transport(NewTransportWrapper(AutoDecompress(true))))},
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{name: "204", method: http.MethodGet, path: "/no-content", want: http.StatusNoContent},
+		{name: "304", method: http.MethodGet, path: "/not-modified", want: http.StatusNotModified},
+		{name: "HEAD", method: http.MethodHead, path: "/", want: http.StatusOK},
+		{name: "empty 200", method: http.MethodGet, path: "/", want: http.StatusOK},
+	}
+
+	for _, c := range clients {
+		t.Cleanup(c.cl.CloseIdleConnections)
+
+		for _, tt := range tests {
+			for _, encoding := range []string{"br", "gzip", "deflate"} {
+				t.Run(c.name+" "+tt.name+" "+encoding, func(t *testing.T) {
+					target := srv.URL + tt.path + "?encoding=" + encoding
+					req, _ := http.NewRequestWithContext(context.Background(), tt.method, target, nil)
+					resp, err := c.cl.Do(req)
+					if err != nil {
+						t.Fatalf("Do() error = %v", err)
+					}
+					defer resp.Body.Close()
+
+					if resp.StatusCode != tt.want {
+						t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+					}
+					b, err := io.ReadAll(resp.Body)
+					if err != nil || len(b) != 0 {
+						t.Errorf("reading the body = %q, %v, want an empty body without error", b, err)
+					}
+				})
+			}
+		}
+	}
+}
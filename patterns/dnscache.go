@@ -0,0 +1,91 @@
+package patterns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// WithDNSCache caches the addresses hosts resolve to for ttl, sparing busy clients a DNS lookup per connection.
+// Connections to a host with several addresses rotate through them, falling back to the next address when a dial fails.
+// Failed lookups are not cached, a lookup returning no addresses fails like a host that doesn't exist.  The cache has
+// no effect on unix socket transports.
+func WithDNSCache(ttl time.Duration) TransportOption {
+	return func(t *TransportWrapper) {
+		t.dns = &dnsCache{
+			ttl:     ttl,
+			lookup:  net.DefaultResolver.LookupHost,
+			entries: make(map[string]*dnsEntry),
+		}
+	}
+}
+
+// dnsCache is an in memory cache of host lookups, it is safe for concurrent use
+type dnsCache struct {
+	ttl    time.Duration
+	lookup func(ctx context.Context, host string) ([]string, error)
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+	next    int // index of the address the next connection starts with
+}
+
+// addrs returns the addresses of host, starting with the next address in the rotation.  The host is resolved when it
+// isn't cached or its entry has expired.
+func (c *dnsCache) addrs(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if !ok || time.Now().After(e.expires) {
+		// don't hold the lock while resolving, concurrent misses for the same host may both resolve
+		c.mu.Unlock()
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		// a custom resolver may return no addresses without an error, there is nothing to dial
+		if len(addrs) == 0 {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+
+		c.mu.Lock()
+		e = &dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+		c.entries[host] = e
+	}
+
+	rotated := append(append([]string(nil), e.addrs[e.next:]...), e.addrs[:e.next]...)
+	e.next = (e.next + 1) % len(e.addrs)
+	c.mu.Unlock()
+
+	return rotated, nil
+}
+
+// dial returns a dial function that resolves through the cache before dialing with d
+func (c *dnsCache) dial(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := c.addrs(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var conn net.Conn
+		for _, ip := range addrs {
+			conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+
+		return conn, err
+	}
+}
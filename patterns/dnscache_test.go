@@ -0,0 +1,140 @@
+package patterns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCachingClient returns a client resolving hosts through a DNS cache backed by lookup instead of the system
+// resolver, keep-alives are disabled so every request dials
+func newCachingClient(ttl time.Duration, lookup func(context.Context, string) ([]string, error)) *ClientWrapper {
+	tr := NewTransportWrapper(WithDNSCache(ttl), DisableKeepAlives(true))
+	tr.dns.lookup = lookup
+
+	return NewClientWrapper(Transport(tr))
+}
+
+func TestDNSCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	url := "http://api.test:" + port
+
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		wait        time.Duration // sleep between the requests
+		addrs       []string      // addresses the host resolves to
+		wantLookups int64
+	}{
+		{name: "cached within the ttl", ttl: time.Minute, addrs: []string{"127.0.0.1"}, wantLookups: 1},
+		{
+			name:        "resolved again once expired",
+			ttl:         time.Millisecond,
+			wait:        5 * time.Millisecond,
+			addrs:       []string{"127.0.0.1"},
+			wantLookups: 3,
+		},
+		{
+			// nothing listens on 127.0.0.2, the dial falls back to the next address
+			name:        "falls back past a dead address",
+			ttl:         time.Minute,
+			addrs:       []string{"127.0.0.2", "127.0.0.1"},
+			wantLookups: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookups int64
+			cl := newCachingClient(tt.ttl, func(ctx context.Context, host string) ([]string, error) {
+				atomic.AddInt64(&lookups, 1)
+				if host != "api.test" {
+					return nil, errors.New("no such host")
+				}
+				return tt.addrs, nil
+			})
+
+			for i := 0; i < 3; i++ {
+				time.Sleep(tt.wait)
+				get(t, cl, url)
+			}
+
+			if got := atomic.LoadInt64(&lookups); got != tt.wantLookups {
+				t.Errorf("%d lookups, want %d", got, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestDNSCacheLookupError(t *testing.T) {
+	tests := []struct {
+		name  string
+		addrs []string
+		err   error
+	}{
+		{name: "resolver error", err: errors.New("no such host")},
+		{name: "no addresses", addrs: []string{}},
+		{name: "nil addresses"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookups int64
+			cl := newCachingClient(time.Minute, func(ctx context.Context, host string) ([]string, error) {
+				atomic.AddInt64(&lookups, 1)
+				return tt.addrs, tt.err
+			})
+
+			for i := 0; i < 2; i++ {
+				resp, err := cl.Get(context.Background(), "http://missing.test/")
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Get() succeeded, want the lookup error")
+				}
+				var dnsErr *net.DNSError
+				if tt.err == nil && (!errors.As(err, &dnsErr) || !dnsErr.IsNotFound) {
+					t.Errorf("Get() error = %v, want a host not found error", err)
+				}
+			}
+
+			// failed lookups are not cached
+			if got := atomic.LoadInt64(&lookups); got != 2 {
+				t.Errorf("%d lookups, want 2", got)
+			}
+		})
+	}
+}
+
+func TestDNSCacheRotation(t *testing.T) {
+	c := &dnsCache{
+		ttl: time.Minute,
+		lookup: func(ctx context.Context, host string) ([]string, error) {
+			return []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil
+		},
+		entries: make(map[string]*dnsEntry),
+	}
+
+	want := [][]string{
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+		{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+	}
+	for i, w := range want {
+		got, err := c.addrs(context.Background(), "api.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, w) {
+			t.Errorf("connection %d tries %v, want %v", i+1, got, w)
+		}
+	}
+}
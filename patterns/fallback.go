@@ -0,0 +1,80 @@
+package patterns
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP1Fallback retries a request over HTTP/1.1 when it fails with an HTTP/2 protocol error, for servers that advertise
+// h2 but break when it is used.  The retry goes through a clone of the client's transport that never attempts HTTP/2,
+// the clone is made when the client is built so it carries the TLS and dialer settings of the transport the client
+// actually uses, including a TransportWrapper.Clone, and CloseIdleConnections closes its idle connections too.  Only
+// requests that can be replayed, those without a body or with GetBody set, are retried.  The fallback has no effect on
+// transports made by NewHTTP2TransportWrapper, which are HTTP/2 only by design.
+func HTTP1Fallback() TransportOption {
+	return func(t *TransportWrapper) {
+		t.h1Fallback = true
+	}
+}
+
+// http1Only returns a clone of tr that never attempts HTTP/2
+func http1Only(tr *http.Transport) *http.Transport {
+	h1 := tr.Clone()
+	h1.ForceAttemptHTTP2 = false
+	h1.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper) // non nil and empty disables HTTP/2
+	if h1.TLSClientConfig != nil {
+		h1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	return h1
+}
+
+// fallback sends requests through next, retrying those that fail with an HTTP/2 protocol error through h1
+func fallback(next, h1 http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if err == nil || !isHTTP2Error(err) {
+			return resp, err
+		}
+
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		return h1.RoundTrip(req)
+	})
+}
+
+// isHTTP2Error reports whether err is an HTTP/2 protocol error.  The HTTP/2 implementation bundled into net/http doesn't
+// export its error types, so its errors are recognized by their messages.
+func isHTTP2Error(err error) bool {
+	var (
+		streamErr http2.StreamError
+		goAwayErr http2.GoAwayError
+		connErr   http2.ConnectionError
+	)
+	if errors.As(err, &streamErr) || errors.As(err, &goAwayErr) || errors.As(err, &connErr) {
+		return true
+	}
+
+	msg := err.Error()
+	for _, s := range []string{"http2:", "stream error:", "connection error:"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
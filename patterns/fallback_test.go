@@ -0,0 +1,76 @@
+package patterns
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newBrokenH2Server starts a TLS server that negotiates h2 but resets every HTTP/2 stream, while serving HTTP/1.1 fine
+func newBrokenH2Server(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			panic(http.ErrAbortHandler) // resets the stream with INTERNAL_ERROR
+		}
+		io.WriteString(w, r.Proto)
+	}))
+	srv.EnableHTTP2 = true
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestHTTP1Fallback(t *testing.T) {
+	srv := newBrokenH2Server(t)
+	pool := certPool(srv)
+
+	tests := []struct {
+		name    string
+		tr      func() *TransportWrapper
+		wantErr bool
+	}{
+		{
+			name: "retries over http/1.1",
+			tr:   func() *TransportWrapper { return NewTransportWrapper(RootCAs(pool), HTTP1Fallback()) },
+		},
+		{
+			name: "clone retries with the clone's tls settings",
+			tr:   func() *TransportWrapper { return NewTransportWrapper(HTTP1Fallback()).Clone(RootCAs(pool)) },
+		},
+		{
+			name:    "no retry without the fallback",
+			tr:      func() *TransportWrapper { return NewTransportWrapper(RootCAs(pool)) },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(Transport(tt.tr()))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Cl.Get(srv.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "HTTP/1.1" {
+				t.Errorf("server saw %s, want HTTP/1.1", body)
+			}
+		})
+	}
+}
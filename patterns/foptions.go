@@ -1,9 +1,25 @@
 package patterns
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // https://www.sohamkamani.com/golang/options-pattern/
@@ -17,16 +33,35 @@ import (
 
 type ClientWrapper struct {
 	Cl http.Client
+
+	wrappers []Middleware      // wrapped around Cl.Transport once all options are applied
+	base     http.RoundTripper // the transport underneath the wrappers
+
+	trWrappers []Middleware                            // installed by transport options, wrapped innermost
+	hooks      []func(req *http.Request) *http.Request // run on requests after all the wrappers, see RequestHook
+
+	strictContext bool // reject requests in Do that don't carry a context
+
+	fallback bool            // retry HTTP/2 protocol errors over HTTP/1.1, set by Transport from HTTP1Fallback
+	h1       *http.Transport // HTTP/1.1 only clone of base the fallback retries through, built by wrap
 }
 
+// ErrMissingContext is returned by Do in strict context mode for requests that don't carry a context
+var ErrMissingContext = errors.New("request has no context, use http.NewRequestWithContext")
+
 type ClientOption func(wrapper *ClientWrapper)
 
+// DefaultTimeout is the timeout of clients built without the Timeout option
+const DefaultTimeout = 30 * time.Second
+
+// NewClientWrapper builds a client from the options.  Requests time out after DefaultTimeout unless the Timeout option
+// sets another limit, so a hung upstream can't block a caller forever, use Timeout(0) to disable the timeout.
 func NewClientWrapper(opts ...ClientOption) *ClientWrapper {
 	c := http.Client{
 		Transport:     http.DefaultTransport,
 		CheckRedirect: nil,
 		Jar:           nil,
-		Timeout:       0,
+		Timeout:       DefaultTimeout,
 	}
 
 	cl := &ClientWrapper{
@@ -37,36 +72,475 @@ func NewClientWrapper(opts ...ClientOption) *ClientWrapper {
 		opt(cl)
 	}
 
+	cl.wrap()
+
 	return cl
 }
 
+// wrap installs the round tripper wrappers around the configured transport.  Wrappers are installed after all options
+// are applied so they compose with the Transport option regardless of the order the options are given in, the first
+// wrapper added is the outermost and sees the request first.  Wrappers installed by transport options sit closest to
+// the transport.  Request hooks run between the two.
+func (c *ClientWrapper) wrap() {
+	c.base = c.Cl.Transport
+	c.h1 = nil
+	if t, ok := c.base.(*http.Transport); ok && c.fallback {
+		c.h1 = http1Only(t)
+		c.Cl.Transport = fallback(c.Cl.Transport, c.h1)
+	}
+	for i := len(c.trWrappers) - 1; i >= 0; i-- {
+		c.Cl.Transport = c.trWrappers[i](c.Cl.Transport)
+	}
+	if len(c.hooks) > 0 {
+		c.Cl.Transport = hook(c.Cl.Transport, c.hooks)
+	}
+	for i := len(c.wrappers) - 1; i >= 0; i-- {
+		c.Cl.Transport = c.wrappers[i](c.Cl.Transport)
+	}
+}
+
+// Clone returns a copy of the client with opts applied on top of the options it was built with, for deriving a variant
+// such as a longer timeout for one endpoint.  An *http.Transport underneath the client is cloned so changes to either
+// client's transport don't affect the other, the clone starts with an empty connection pool.  The wrapper options are
+// reinstalled on the copy, so stateful ones such as CircuitBreaker start fresh.  The cookie jar is shared.
+func (c *ClientWrapper) Clone(opts ...ClientOption) *ClientWrapper {
+	cl := &ClientWrapper{
+		Cl:            c.Cl,
+		wrappers:      append([]Middleware(nil), c.wrappers...),
+		trWrappers:    append([]Middleware(nil), c.trWrappers...),
+		hooks:         append([]func(*http.Request) *http.Request(nil), c.hooks...),
+		strictContext: c.strictContext,
+		fallback:      c.fallback,
+	}
+
+	cl.Cl.Transport = c.base
+	if t, ok := c.base.(*http.Transport); ok {
+		cl.Cl.Transport = t.Clone()
+	}
+
+	for _, opt := range opts {
+		opt(cl)
+	}
+
+	cl.wrap()
+
+	return cl
+}
+
+// Do sends an HTTP request using the underlying client.  When the StrictContext option is set, requests carrying the
+// background or TODO context are rejected with ErrMissingContext to encourage context propagation.
+func (c *ClientWrapper) Do(req *http.Request) (*http.Response, error) {
+	if c.strictContext {
+		if ctx := req.Context(); ctx == context.Background() || ctx == context.TODO() {
+			// match http.Client.Do which always closes the request body
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, ErrMissingContext
+		}
+	}
+
+	return c.Cl.Do(req)
+}
+
+// Get issues a GET to the specified URL, the request is cancelled when ctx is done
+func (c *ClientWrapper) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+// Post issues a POST to the specified URL, the request is cancelled when ctx is done
+func (c *ClientWrapper) Post(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	return c.Do(req)
+}
+
+// WarmUp primes the transport's idle connection pool by issuing conns concurrent HEAD requests to url, so the first real
+// requests don't pay the connection setup cost.  conns is capped at the transport's MaxIdleConnsPerHost since any extra
+// connections would be closed as soon as they go idle.  Zero conns is a no-op and a negative conns is an error.
+func (c *ClientWrapper) WarmUp(ctx context.Context, url string, conns int) error {
+	if conns < 0 {
+		return fmt.Errorf("warm up: conns must not be negative, got %d", conns)
+	}
+	if conns == 0 {
+		return nil
+	}
+
+	if t := c.Transport(); t != nil {
+		perHost := t.MaxIdleConnsPerHost
+		if perHost == 0 {
+			perHost = http.DefaultMaxIdleConnsPerHost
+		}
+		if conns > perHost {
+			conns = perHost
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, conns)
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			resp, err := c.Do(req)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			// drain and close so the connection goes back to the idle pool
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Transport returns the *http.Transport underneath any wrappers installed by the client options, or nil if the client
+// was configured with some other http.RoundTripper, such as the transport of NewHTTP2TransportWrapper.
+func (c *ClientWrapper) Transport() *http.Transport {
+	t, _ := c.base.(*http.Transport)
+
+	return t
+}
+
+// CloseIdleConnections closes any idle connections held by the underlying transport
+func (c *ClientWrapper) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+
+	// the wrappers don't forward CloseIdleConnections so go straight to the underlying transport
+	if t, ok := c.base.(closeIdler); ok {
+		t.CloseIdleConnections()
+	}
+	if c.h1 != nil {
+		c.h1.CloseIdleConnections()
+	}
+}
+
+// Middleware wraps a round tripper with a cross-cutting concern such as auth, logging, or retries.  The wrapping client
+// options are all middleware underneath.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// Use installs middleware around the client's transport.  Middleware is layered in the order it is declared across all
+// the options, Use and the wrapping options alike: the first is the outermost, seeing the request first and the
+// response last, so Use(retry, logging) logs every attempt while Use(logging, retry) logs once per request.  Middleware
+// installed by transport options, such as AutoDecompress, always sits innermost, next to the transport.
+func Use(mw ...Middleware) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, mw...)
+	}
+}
+
+// RequestHook runs fn on every outgoing request just before it is sent, letting callers adjust it, e.g. to add baggage
+// or headers derived from the request context, and send the request fn returns instead.  Hooks see the final request,
+// after every other client option has had its turn, and run in the order they were added.  fn is given a copy of the
+// request so it may modify it freely.
+func RequestHook(fn func(req *http.Request) *http.Request) ClientOption {
+	return func(c *ClientWrapper) {
+		c.hooks = append(c.hooks, fn)
+	}
+}
+
+// hook returns a round tripper running the hooks on each request before passing it on to next
+func hook(next http.RoundTripper, hooks []func(req *http.Request) *http.Request) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		for _, fn := range hooks {
+			req = fn(req)
+		}
+
+		return next.RoundTrip(req)
+	})
+}
+
+// roundTripperFunc adapts a function to the http.RoundTripper interface
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Timeout limits the time a request may take including reading the response body, zero means no limit.  The default is
+// DefaultTimeout.
 func Timeout(t time.Duration) ClientOption {
 	return func(c *ClientWrapper) {
 		c.Cl.Timeout = t
 	}
 }
 
+// RequestDeadline bounds the time each request may take to receive its response headers.  Unlike Timeout, which
+// covers the whole exchange including reading the body, the deadline stops once the headers arrive, so a streaming
+// response can be read for as long as it takes.  The two combine, when both are set the request fails at whichever
+// expires first, so disable the timeout with Timeout(0) for clients that stream.  A request that misses the deadline
+// fails with an error wrapping context.DeadlineExceeded.
+func RequestDeadline(d time.Duration) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				ctx, cancel := context.WithCancel(req.Context())
+				timer := time.AfterFunc(d, cancel)
+
+				resp, err := next.RoundTrip(req.WithContext(ctx))
+				if !timer.Stop() {
+					// the deadline passed, even if the headers made it the body is already cancelled
+					if resp != nil {
+						resp.Body.Close()
+					}
+					cancel()
+					return nil, fmt.Errorf("no response headers within %s: %w", d, context.DeadlineExceeded)
+				}
+				if err != nil {
+					cancel()
+					return nil, err
+				}
+
+				// the request context lives on with the body, release it once the body is closed
+				resp.Body = readCloser{
+					Reader: resp.Body,
+					Closer: cancelCloser{Closer: resp.Body, cancel: cancel},
+				}
+
+				return resp, nil
+			})
+		})
+	}
+}
+
+// cancelCloser cancels a context after closing the body that depends on it
+type cancelCloser struct {
+	io.Closer
+	cancel context.CancelFunc
+}
+
+func (c cancelCloser) Close() error {
+	err := c.Closer.Close()
+	c.cancel()
+
+	return err
+}
+
 func Transport(tr *TransportWrapper) ClientOption {
 	return func(c *ClientWrapper) {
 		c.Cl.Transport = tr.Tr
+		if tr.H2 != nil {
+			c.Cl.Transport = tr.h2RoundTripper()
+		}
+		c.trWrappers = tr.wrappers
+		c.fallback = tr.h1Fallback && tr.H2 == nil
+	}
+}
+
+// RoundTripper sends requests through rt instead of a TransportWrapper, for injecting a stub transport in tests or an
+// http.RoundTripper from another package.  The other client options still wrap rt.
+func RoundTripper(rt http.RoundTripper) ClientOption {
+	return func(c *ClientWrapper) {
+		c.Cl.Transport = rt
+		c.trWrappers = nil
+		c.fallback = false
+	}
+}
+
+// ErrInsecureScheme is returned for plaintext requests made by a client built with RequireHTTPS
+var ErrInsecureScheme = errors.New("request url is not https")
+
+// RequireHTTPS rejects any request that isn't https with ErrInsecureScheme before a connection is dialed, guarding
+// against accidental plaintext calls, redirects included.  Requests to the allowed hosts, such as localhost, may still
+// use http, hosts are matched case insensitively without the port.
+func RequireHTTPS(allow ...string) ClientOption {
+	allowed := make(map[string]bool, len(allow))
+	for _, host := range allow {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Scheme != "https" && !allowed[strings.ToLower(req.URL.Hostname())] {
+					if req.Body != nil {
+						req.Body.Close()
+					}
+					return nil, fmt.Errorf("%w: %s", ErrInsecureScheme, req.URL.Redacted())
+				}
+
+				return next.RoundTrip(req)
+			})
+		})
+	}
+}
+
+// StrictContext makes Do reject requests that don't carry a context
+func StrictContext() ClientOption {
+	return func(c *ClientWrapper) {
+		c.strictContext = true
+	}
+}
+
+// CheckRedirect sets the redirect policy of the client, return http.ErrUseLastResponse from fn to stop following
+// redirects and return the redirect response to the caller.
+func CheckRedirect(fn func(req *http.Request, via []*http.Request) error) ClientOption {
+	return func(c *ClientWrapper) {
+		c.Cl.CheckRedirect = fn
+	}
+}
+
+// MaxRedirects limits the number of redirects the client follows, requests that would exceed n redirects fail.  A value of
+// 0 forbids redirects entirely.
+func MaxRedirects(n int) ClientOption {
+	return CheckRedirect(func(req *http.Request, via []*http.Request) error {
+		if len(via) > n { // via holds the original request and every redirect followed so far
+			return fmt.Errorf("stopped after %d redirects", n)
+		}
+
+		return nil
+	})
+}
+
+// CookieJar sets the cookie jar used to persist cookies across requests
+func CookieJar(jar http.CookieJar) ClientOption {
+	return func(c *ClientWrapper) {
+		c.Cl.Jar = jar
+	}
+}
+
+// DefaultCookieJar enables cookie persistence across requests using an in memory cookie jar
+func DefaultCookieJar() ClientOption {
+	return func(c *ClientWrapper) {
+		// cookiejar.New never returns an error when no options are given
+		jar, _ := cookiejar.New(nil)
+		c.Cl.Jar = jar
+	}
+}
+
+// BasicAuth sets HTTP Basic Auth credentials on every request
+func BasicAuth(username, password string) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				// a round tripper must not modify the caller's request
+				req = req.Clone(req.Context())
+				req.SetBasicAuth(username, password)
+
+				return next.RoundTrip(req)
+			})
+		})
+	}
+}
+
+// BearerToken sets a bearer token on every request, tokenFunc is called for each request so callers can plug in their own
+// token caching and refresh.  If tokenFunc returns an error the request fails without being sent.
+func BearerToken(tokenFunc func() (string, error)) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				token, err := tokenFunc()
+				if err != nil {
+					// round trippers must always close the request body, even on error
+					if req.Body != nil {
+						req.Body.Close()
+					}
+					return nil, fmt.Errorf("bearer token: %w", err)
+				}
+
+				req = req.Clone(req.Context())
+				req.Header.Set("Authorization", "Bearer "+token)
+
+				return next.RoundTrip(req)
+			})
+		})
+	}
+}
+
+// UserAgent sets the User-Agent header on requests that don't already carry one.  Header options are applied in the order
+// they are given, so an earlier UserAgent option takes precedence over a later one.
+func UserAgent(ua string) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if req.Header.Get("User-Agent") != "" {
+					return next.RoundTrip(req)
+				}
+
+				req = req.Clone(req.Context())
+				req.Header.Set("User-Agent", ua)
+
+				return next.RoundTrip(req)
+			})
+		})
+	}
+}
+
+// Headers adds a fixed set of headers to every request, headers already set on the request by the caller take precedence
+func Headers(h http.Header) ClientOption {
+	// copy so later changes by the caller don't race with requests in flight
+	h = h.Clone()
+
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				// Clone deep copies the header map so the caller's request is never modified
+				req = req.Clone(req.Context())
+				for k, v := range h {
+					if _, ok := req.Header[k]; !ok {
+						req.Header[k] = append([]string(nil), v...)
+					}
+				}
+
+				return next.RoundTrip(req)
+			})
+		})
 	}
 }
 
 // transport options
 type TransportWrapper struct {
 	Tr *http.Transport
+
+	dialer *net.Dialer // kept so options can tune it, Tr.DialContext is rebuilt from it once all options are applied
+	socket string      // unix socket every connection is dialed to, set by UnixSocket
+	dns    *dnsCache   // resolves hosts before dialing when set by WithDNSCache
+
+	wrappers []Middleware // round trippers installed around Tr by the Transport client option
+
+	H2      *http2.Transport // set by NewHTTP2TransportWrapper, used by the Transport client option in place of Tr
+	h2c     bool             // speak cleartext HTTP/2 to http:// URLs, only used by NewHTTP2TransportWrapper
+	h2Clear *http2.Transport // cleartext HTTP/2 transport for http:// URLs, set alongside H2 when h2c is set
+
+	h1Fallback bool // retry HTTP/2 protocol errors over HTTP/1.1, set by HTTP1Fallback
+
+	errs []error // invalid option arguments, the options are not applied and NewTransportWrapperStrict reports them
 }
 
 type TransportOption func(wrapper *TransportWrapper)
 
 func NewTransportWrapper(opts ...TransportOption) *TransportWrapper {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
 	t := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.DialContext,
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
@@ -74,13 +548,126 @@ func NewTransportWrapper(opts ...TransportOption) *TransportWrapper {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 	tr := &TransportWrapper{
-		Tr: t,
+		Tr:     t,
+		dialer: d,
 	}
 
 	for _, opt := range opts {
 		opt(tr)
 	}
 
+	tr.dial()
+
+	return tr
+}
+
+// ErrInvalidTransport is wrapped by the errors NewTransportWrapperStrict returns for nonsensical option values
+var ErrInvalidTransport = errors.New("invalid transport configuration")
+
+// NewTransportWrapperStrict is NewTransportWrapper with the resulting configuration validated, negative sizes and
+// timeouts and per host limits exceeding the overall idle limit are rejected rather than left to silently misbehave.
+// Every problem found is reported, each wrapping ErrInvalidTransport.
+func NewTransportWrapperStrict(opts ...TransportOption) (*TransportWrapper, error) {
+	tr := NewTransportWrapper(opts...)
+	if err := tr.validate(); err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+// validate checks the transport configuration for values the transport would misinterpret or ignore
+func (t *TransportWrapper) validate() error {
+	errs := append([]error(nil), t.errs...)
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidTransport}, args...)...))
+	}
+
+	sizes := []struct {
+		name string
+		n    int64
+	}{
+		{"max idle conns", int64(t.Tr.MaxIdleConns)},
+		{"max idle conns per host", int64(t.Tr.MaxIdleConnsPerHost)},
+		{"max conns per host", int64(t.Tr.MaxConnsPerHost)},
+		{"max response header bytes", t.Tr.MaxResponseHeaderBytes},
+		{"write buffer size", int64(t.Tr.WriteBufferSize)},
+		{"read buffer size", int64(t.Tr.ReadBufferSize)},
+	}
+	for _, size := range sizes {
+		if size.n < 0 {
+			invalid("%s is negative: %d", size.name, size.n)
+		}
+	}
+
+	// a negative keep-alive is meaningful, it disables keep-alive probes
+	timeouts := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"dial timeout", t.dialer.Timeout},
+		{"idle conn timeout", t.Tr.IdleConnTimeout},
+		{"response header timeout", t.Tr.ResponseHeaderTimeout},
+		{"tls handshake timeout", t.Tr.TLSHandshakeTimeout},
+		{"expect continue timeout", t.Tr.ExpectContinueTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.d < 0 {
+			invalid("%s is negative: %s", timeout.name, timeout.d)
+		}
+	}
+
+	if t.Tr.MaxIdleConns > 0 && t.Tr.MaxIdleConnsPerHost > t.Tr.MaxIdleConns {
+		invalid("max idle conns per host %d exceeds max idle conns %d", t.Tr.MaxIdleConnsPerHost, t.Tr.MaxIdleConns)
+	}
+	if t.Tr.MaxConnsPerHost > 0 && t.Tr.MaxIdleConnsPerHost > t.Tr.MaxConnsPerHost {
+		invalid("max idle conns per host %d exceeds max conns per host %d", t.Tr.MaxIdleConnsPerHost, t.Tr.MaxConnsPerHost)
+	}
+
+	return errors.Join(errs...)
+}
+
+// dial rebuilds Tr.DialContext from the dialer once the options have been applied
+func (t *TransportWrapper) dial() {
+	t.Tr.DialContext = t.dialer.DialContext
+	if t.dns != nil {
+		t.Tr.DialContext = t.dns.dial(t.dialer)
+	}
+	if t.socket != "" {
+		socket, dialer := t.socket, t.dialer
+		t.Tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+}
+
+// Clone returns a copy of the transport with opts applied on top of the options it was built with, so a base transport
+// config can be shared and tweaked per client.  The copy is made with http.Transport.Clone and has its own dialer, so
+// changes to the clone don't affect the source, it starts with an empty connection pool.  A clone of an HTTP/2 only
+// transport is HTTP/2 only as well, and a DNS cache is shared between the two.
+func (t *TransportWrapper) Clone(opts ...TransportOption) *TransportWrapper {
+	d := *t.dialer
+	tr := &TransportWrapper{
+		Tr:       t.Tr.Clone(),
+		dialer:   &d,
+		socket:   t.socket,
+		dns:      t.dns,
+		wrappers: append([]Middleware(nil), t.wrappers...),
+		h2c:      t.h2c,
+		errs:     append([]error(nil), t.errs...),
+
+		h1Fallback: t.h1Fallback,
+	}
+
+	for _, opt := range opts {
+		opt(tr)
+	}
+
+	tr.dial()
+	if t.H2 != nil {
+		tr.configureHTTP2()
+	}
+
 	return tr
 }
 
@@ -96,14 +683,217 @@ func MaxIdleConsPerHost(ich int) TransportOption {
 	}
 }
 
+// MaxConsPerHost caps the number of connections per host, dialing, active, and idle, requests beyond the cap wait for a
+// connection to free up.  The cap is enforced by the transport itself so it holds however many client options wrap it,
+// and across every client sharing the transport.  Zero means no limit.
 func MaxConsPerHost(cph int) TransportOption {
 	return func(t *TransportWrapper) {
 		t.Tr.MaxConnsPerHost = cph
 	}
 }
 
+// IdleConnProfile sets the total and per host idle connection limits together, capturing the usual tuning intent in one
+// call.  A total of zero means no limit on the total.  A profile with a negative limit, or with perHost above a non zero
+// total, is invalid, it is not applied and NewTransportWrapperStrict rejects it.
+func IdleConnProfile(total, perHost int) TransportOption {
+	return func(t *TransportWrapper) {
+		switch {
+		case total < 0 || perHost < 0:
+			t.errs = append(t.errs, fmt.Errorf("%w: idle conn profile is negative: total %d, per host %d", ErrInvalidTransport, total, perHost))
+			return
+		case total > 0 && perHost > total:
+			t.errs = append(t.errs, fmt.Errorf("%w: idle conn profile per host %d exceeds total %d", ErrInvalidTransport, perHost, total))
+			return
+		}
+
+		t.Tr.MaxIdleConns = total
+		t.Tr.MaxIdleConnsPerHost = perHost
+	}
+}
+
 func IdleConTimeout(ict time.Duration) TransportOption {
 	return func(t *TransportWrapper) {
 		t.Tr.IdleConnTimeout = ict
 	}
 }
+
+// tlsConfig returns the transport's TLS config, allocating it first if needed so multiple TLS options compose
+func (t *TransportWrapper) tlsConfig() *tls.Config {
+	if t.Tr.TLSClientConfig == nil {
+		t.Tr.TLSClientConfig = &tls.Config{}
+	}
+
+	return t.Tr.TLSClientConfig
+}
+
+// ClientCert adds a client certificate presented to servers requiring mutual TLS
+func ClientCert(cert tls.Certificate) TransportOption {
+	return func(t *TransportWrapper) {
+		cfg := t.tlsConfig()
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+}
+
+// RootCAs sets the certificate authorities used to verify server certificates
+func RootCAs(pool *x509.CertPool) TransportOption {
+	return func(t *TransportWrapper) {
+		t.tlsConfig().RootCAs = pool
+	}
+}
+
+// InsecureSkipVerify disables verification of server certificates, this is intended for development against self signed
+// endpoints and must never be enabled in production.
+func InsecureSkipVerify(skip bool) TransportOption {
+	return func(t *TransportWrapper) {
+		if skip {
+			log.Println("WARNING: TLS certificate verification is disabled, connections are vulnerable to interception")
+		}
+		t.tlsConfig().InsecureSkipVerify = skip
+	}
+}
+
+// MinTLSVersion sets the lowest TLS version the transport will negotiate, e.g. tls.VersionTLS12, handshakes with servers
+// that only offer older versions fail
+func MinTLSVersion(v uint16) TransportOption {
+	return func(t *TransportWrapper) {
+		t.tlsConfig().MinVersion = v
+	}
+}
+
+// ErrCertificatePin is returned when a server's certificate doesn't match any of the pins set by PinCertificates
+var ErrCertificatePin = errors.New("server certificate does not match any pinned public key")
+
+// CertificatePin returns the pin of cert for PinCertificates, the SHA-256 hash of its subject public key info
+func CertificatePin(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return sum[:]
+}
+
+// PinCertificates rejects connections to servers whose leaf certificate public key doesn't hash to one of pins, see
+// CertificatePin.  Pinning the public key rather than the certificate lets the server renew its certificate with the
+// same key.  Pinning is checked on top of the usual certificate verification, on every connection including resumed
+// TLS sessions.
+func PinCertificates(pins ...[]byte) TransportOption {
+	return func(t *TransportWrapper) {
+		// VerifyConnection rather than VerifyPeerCertificate, which is skipped for resumed sessions
+		t.tlsConfig().VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return ErrCertificatePin
+			}
+
+			pin := CertificatePin(cs.PeerCertificates[0])
+			for _, allowed := range pins {
+				if subtle.ConstantTimeCompare(pin, allowed) == 1 {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("%w: %s", ErrCertificatePin, base64.StdEncoding.EncodeToString(pin))
+		}
+	}
+}
+
+// DialTimeout sets the maximum amount of time a dial will wait for a connection to be established
+func DialTimeout(d time.Duration) TransportOption {
+	return func(t *TransportWrapper) {
+		t.dialer.Timeout = d
+	}
+}
+
+// KeepAlive sets the interval between keep-alive probes for active connections, a negative value disables keep-alive
+// probes
+func KeepAlive(d time.Duration) TransportOption {
+	return func(t *TransportWrapper) {
+		t.dialer.KeepAlive = d
+	}
+}
+
+// LocalAddr makes outbound connections originate from addr, for picking the interface requests leave from on multi
+// homed hosts.  A zero port lets the system pick one.
+func LocalAddr(addr *net.TCPAddr) TransportOption {
+	return func(t *TransportWrapper) {
+		t.dialer.LocalAddr = addr
+	}
+}
+
+// DisableKeepAlives prevents the transport from reusing connections, each request opens a new connection
+func DisableKeepAlives(disable bool) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.DisableKeepAlives = disable
+	}
+}
+
+// UnixSocket dials every connection to the unix domain socket at path, whatever the host of the request, for talking to
+// services such as the Docker daemon.  The request URL still needs a host, e.g. http://localhost/info, it is sent in the
+// Host header but not used to connect.  The dialer options still apply.
+func UnixSocket(path string) TransportOption {
+	return func(t *TransportWrapper) {
+		t.socket = path
+	}
+}
+
+// Proxy sends all requests through the proxy at proxyURL instead of the proxy configured in the environment
+func Proxy(proxyURL *url.URL) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.Proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// NoProxy disables proxying entirely, including any proxy configured in the environment
+func NoProxy() TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.Proxy = nil
+	}
+}
+
+// ResponseHeaderTimeout sets the amount of time to wait for the response headers after the request is fully written
+func ResponseHeaderTimeout(d time.Duration) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.ResponseHeaderTimeout = d
+	}
+}
+
+// TLSHandshakeTimeout sets the maximum amount of time to wait for a TLS handshake, zero means no timeout
+func TLSHandshakeTimeout(d time.Duration) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.TLSHandshakeTimeout = d
+	}
+}
+
+// ExpectContinueTimeout sets the amount of time to wait for a server's first response headers after fully writing the
+// request headers when the request has an "Expect: 100-continue" header, zero sends the body immediately
+func ExpectContinueTimeout(d time.Duration) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.ExpectContinueTimeout = d
+	}
+}
+
+// ForceAttemptHTTP2 controls whether HTTP/2 is attempted when a custom dialer or TLS config is set, disable it for
+// strict HTTP/1.1 behavior
+func ForceAttemptHTTP2(force bool) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.ForceAttemptHTTP2 = force
+	}
+}
+
+// MaxResponseHeaderBytes limits the size of the response headers the transport will read, zero uses the default limit
+func MaxResponseHeaderBytes(n int64) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.MaxResponseHeaderBytes = n
+	}
+}
+
+// WriteBufferSize sets the size of the write buffer used when writing to the connection, Go uses 4096 bytes when unset
+func WriteBufferSize(n int) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.WriteBufferSize = n
+	}
+}
+
+// ReadBufferSize sets the size of the read buffer used when reading from the connection, Go uses 4096 bytes when unset
+func ReadBufferSize(n int) TransportOption {
+	return func(t *TransportWrapper) {
+		t.Tr.ReadBufferSize = n
+	}
+}
@@ -0,0 +1,1657 @@
+package patterns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingListener tracks the number of connections accepted, open at once, and the most ever open
+type countingListener struct {
+	net.Listener
+	accepted, open, max int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&l.accepted, 1)
+	n := atomic.AddInt64(&l.open, 1)
+	for {
+		max := atomic.LoadInt64(&l.max)
+		if n <= max || atomic.CompareAndSwapInt64(&l.max, max, n) {
+			break
+		}
+	}
+
+	return &countedConn{Conn: conn, l: l}, nil
+}
+
+// countedConn is a connection accepted by a countingListener, it is counted as open until closed
+type countedConn struct {
+	net.Conn
+	l    *countingListener
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.l.open, -1) })
+
+	return c.Conn.Close()
+}
+
+// requestRecorder is a handler keeping the last request it served
+type requestRecorder struct {
+	mu   sync.Mutex
+	last *http.Request
+}
+
+func (rr *requestRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.last = r.Clone(context.Background())
+}
+
+// request returns the last request served
+func (rr *requestRecorder) request() *http.Request {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	return rr.last
+}
+
+// newRecordingServer starts a server responding 200 OK that records the last request it served, it is closed when the
+// test ends
+func newRecordingServer(t *testing.T) (*httptest.Server, *requestRecorder) {
+	t.Helper()
+
+	rr := &requestRecorder{}
+	srv := httptest.NewServer(rr)
+	t.Cleanup(srv.Close)
+
+	return srv, rr
+}
+
+// get issues a GET to url with cl and discards the response body, failing the test on error
+func get(t *testing.T, cl *ClientWrapper, url string) *http.Response {
+	t.Helper()
+
+	resp, err := cl.Get(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return resp
+}
+
+func TestBasicAuth(t *testing.T) {
+	srv, rr := newRecordingServer(t)
+
+	tests := []struct {
+		name     string
+		header   string // Authorization header set by the caller
+		user     string
+		password string
+	}{
+		{name: "sets credentials", user: "alice", password: "secret"},
+		{name: "empty password", user: "bob"},
+		{name: "replaces the caller's credentials", header: "Basic Zm9vOmJhcg==", user: "carol", password: "pw"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(BasicAuth(tt.user, tt.password))
+
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := cl.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			user, password, ok := rr.request().BasicAuth()
+			if !ok || user != tt.user || password != tt.password {
+				t.Errorf("server got %q, %q, %t, want %q, %q", user, password, ok, tt.user, tt.password)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.header {
+				t.Errorf("caller's request modified, Authorization = %q, want %q", got, tt.header)
+			}
+		})
+	}
+}
+
+func TestMaxConsPerHost(t *testing.T) {
+	const (
+		limit    = 2
+		requests = 20
+	)
+
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name string
+		opts func(tr *TransportWrapper) []ClientOption
+	}{
+		{
+			name: "transport only",
+			opts: func(tr *TransportWrapper) []ClientOption { return []ClientOption{Transport(tr)} },
+		},
+		{
+			name: "wrapped by client options",
+			opts: func(tr *TransportWrapper) []ClientOption {
+				return []ClientOption{
+					Transport(tr),
+					WithLogger(discard),
+					Retry(2, time.Millisecond),
+					CircuitBreaker(requests, time.Second),
+					UserAgent("test"),
+				}
+			},
+		},
+		{
+			name: "cloned transport",
+			opts: func(tr *TransportWrapper) []ClientOption {
+				return []ClientOption{Transport(tr.Clone()), WithLogger(discard)}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight, maxInFlight int64
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt64(&inFlight, 1)
+				defer atomic.AddInt64(&inFlight, -1)
+				for {
+					max := atomic.LoadInt64(&maxInFlight)
+					if n <= max || atomic.CompareAndSwapInt64(&maxInFlight, max, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+			}))
+			ln := &countingListener{Listener: srv.Listener}
+			srv.Listener = ln
+			srv.Start()
+			t.Cleanup(srv.Close)
+
+			cl := NewClientWrapper(tt.opts(NewTransportWrapper(MaxConsPerHost(limit)))...)
+			t.Cleanup(cl.CloseIdleConnections)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, requests)
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := cl.Get(context.Background(), srv.URL)
+					if err != nil {
+						errs <- err
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Errorf("Get() error = %v", err)
+			}
+			if got := atomic.LoadInt64(&ln.max); got > limit {
+				t.Errorf("%d connections open at once, want at most %d", got, limit)
+			}
+			if got := atomic.LoadInt64(&maxInFlight); got > limit || got < 1 {
+				t.Errorf("%d requests in flight at once, want between 1 and %d", got, limit)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	srv, rr := newRecordingServer(t)
+
+	t.Run("refreshed per request", func(t *testing.T) {
+		var calls int64
+		cl := NewClientWrapper(BearerToken(func() (string, error) {
+			return fmt.Sprintf("token-%d", atomic.AddInt64(&calls, 1)), nil
+		}))
+
+		for _, want := range []string{"Bearer token-1", "Bearer token-2"} {
+			get(t, cl, srv.URL)
+			if got := rr.request().Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("token error", func(t *testing.T) {
+		tokenErr := errors.New("refresh failed")
+		cl := NewClientWrapper(BearerToken(func() (string, error) { return "", tokenErr }))
+
+		before := rr.request()
+		resp, err := cl.Get(context.Background(), srv.URL)
+		if err == nil {
+			resp.Body.Close()
+			t.Fatal("Get() succeeded, want the token error")
+		}
+		if !errors.Is(err, tokenErr) {
+			t.Errorf("Get() error = %v, want it to wrap %v", err, tokenErr)
+		}
+		if rr.request() != before {
+			t.Error("request sent without a token")
+		}
+	})
+}
+
+func TestUserAgent(t *testing.T) {
+	srv, rr := newRecordingServer(t)
+
+	tests := []struct {
+		name   string
+		opts   []ClientOption
+		header string // User-Agent set by the caller
+		want   string
+	}{
+		{name: "sets the header", opts: []ClientOption{UserAgent("examples/1.0")}, want: "examples/1.0"},
+		{
+			name:   "caller's header kept",
+			opts:   []ClientOption{UserAgent("examples/1.0")},
+			header: "custom/2.0",
+			want:   "custom/2.0",
+		},
+		{
+			name: "earlier option wins",
+			opts: []ClientOption{UserAgent("first"), UserAgent("second")},
+			want: "first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(tt.opts...)
+
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+			if tt.header != "" {
+				req.Header.Set("User-Agent", tt.header)
+			}
+			resp, err := cl.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if got := rr.request().UserAgent(); got != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newRedirectServer starts a server redirecting /hop/n to /hop/n-1 and answering /hop/0 with 200 OK, it is closed when
+// the test ends
+func newRedirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(r.URL.Path, "/hop/%d", &n)
+		if n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestCheckRedirect(t *testing.T) {
+	srv := newRedirectServer(t)
+
+	tests := []struct {
+		name   string
+		policy func(req *http.Request, via []*http.Request) error
+		want   int
+	}{
+		{name: "follows redirects", policy: func(*http.Request, []*http.Request) error { return nil }, want: 200},
+		{
+			name:   "returns the redirect",
+			policy: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			want:   http.StatusFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(CheckRedirect(tt.policy))
+
+			if got := get(t, cl, srv.URL+"/hop/2").StatusCode; got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	rr := &requestRecorder{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		}
+		rr.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	jar, _ := cookiejar.New(nil)
+
+	tests := []struct {
+		name string
+		opts []ClientOption
+		want string // session cookie sent after logging in
+	}{
+		{name: "no jar"},
+		{name: "default jar", opts: []ClientOption{DefaultCookieJar()}, want: "abc"},
+		{name: "custom jar", opts: []ClientOption{CookieJar(jar)}, want: "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(tt.opts...)
+
+			get(t, cl, srv.URL+"/login")
+			get(t, cl, srv.URL+"/profile")
+
+			var got string
+			if c, err := rr.request().Cookie("session"); err == nil {
+				got = c.Value
+			}
+			if got != tt.want {
+				t.Errorf("session cookie = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.String())
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	// the server's own key pair stands in for a client certificate, the server asks for one without verifying it
+	cert := srv.TLS.Certificates[0]
+
+	tests := []struct {
+		name    string
+		opts    []TransportOption
+		wantErr bool
+	}{
+		{name: "presents the certificate", opts: []TransportOption{ClientCert(cert)}},
+		{name: "no certificate", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]TransportOption{RootCAs(certPool(srv))}, tt.opts...)
+			cl := NewClientWrapper(Transport(NewTransportWrapper(opts...)))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Get(context.Background(), srv.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Get() succeeded, want the handshake to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+			if b, _ := io.ReadAll(resp.Body); string(b) != leaf.Subject.String() {
+				t.Errorf("server saw certificate %q, want %q", b, leaf.Subject)
+			}
+		})
+	}
+}
+
+func TestInsecureSkipVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name    string
+		skip    bool
+		wantErr bool
+	}{
+		{name: "verifies by default", wantErr: true},
+		{name: "skips verification", skip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(Transport(NewTransportWrapper(InsecureSkipVerify(tt.skip))))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Get(context.Background(), srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	tr := NewTransportWrapper(DialTimeout(timeout))
+	if tr.dialer.Timeout != timeout {
+		t.Errorf("dialer timeout = %v, want %v", tr.dialer.Timeout, timeout)
+	}
+
+	// a non routable address, the SYN goes unanswered so only the dial timeout ends the dial
+	cl := NewClientWrapper(Transport(tr))
+	start := time.Now()
+	resp, err := cl.Get(context.Background(), "http://10.255.255.1:81")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Get() succeeded, want the dial to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*timeout {
+		t.Errorf("dial failed after %v, want about %v", elapsed, timeout)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []TransportOption
+		want time.Duration
+	}{
+		{name: "default", want: 30 * time.Second},
+		{name: "custom", opts: []TransportOption{KeepAlive(15 * time.Second)}, want: 15 * time.Second},
+		{name: "disabled", opts: []TransportOption{KeepAlive(-1)}, want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewTransportWrapper(tt.opts...).dialer.KeepAlive; got != tt.want {
+				t.Errorf("dialer keep-alive = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisableKeepAlives(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable bool
+		want    int64 // connections accepted for two requests
+	}{
+		{name: "reuses connections", want: 1},
+		{name: "fresh connection per request", disable: true, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			ln := &countingListener{Listener: srv.Listener}
+			srv.Listener = ln
+			srv.Start()
+			t.Cleanup(srv.Close)
+
+			cl := NewClientWrapper(Transport(NewTransportWrapper(DisableKeepAlives(tt.disable))))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			get(t, cl, srv.URL)
+			get(t, cl, srv.URL)
+
+			if got := atomic.LoadInt64(&ln.accepted); got != tt.want {
+				t.Errorf("%d connections accepted, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	proxy, proxied := newRecordingServer(t)
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	t.Run("forwards to the proxy", func(t *testing.T) {
+		cl := NewClientWrapper(Transport(NewTransportWrapper(Proxy(proxyURL))))
+		t.Cleanup(cl.CloseIdleConnections)
+
+		// the host doesn't exist, only the proxy can answer
+		get(t, cl, "http://upstream.invalid/path")
+
+		req := proxied.request()
+		if req == nil {
+			t.Fatal("request didn't reach the proxy")
+		}
+		if req.Host != "upstream.invalid" || req.URL.Path != "/path" {
+			t.Errorf("proxy got %s %s, want upstream.invalid /path", req.Host, req.URL.Path)
+		}
+	})
+
+	t.Run("no proxy", func(t *testing.T) {
+		srv, direct := newRecordingServer(t)
+
+		tr := NewTransportWrapper(Proxy(proxyURL), NoProxy())
+		if tr.Tr.Proxy != nil {
+			t.Error("transport still has a proxy")
+		}
+		cl := NewClientWrapper(Transport(tr))
+		t.Cleanup(cl.CloseIdleConnections)
+
+		before := proxied.request()
+		get(t, cl, srv.URL)
+		if direct.request() == nil {
+			t.Error("request didn't reach the server")
+		}
+		if proxied.request() != before {
+			t.Error("request went through the proxy")
+		}
+	})
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	cl := NewClientWrapper(Transport(NewTransportWrapper(ResponseHeaderTimeout(timeout))))
+	t.Cleanup(cl.CloseIdleConnections)
+
+	start := time.Now()
+	resp, err := cl.Get(context.Background(), srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Get() succeeded, want a timeout")
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Get() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*timeout {
+		t.Errorf("Get() returned after %v, want about %v", elapsed, timeout)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	// accepts connections and never answers the client hello
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	cl := NewClientWrapper(Transport(NewTransportWrapper(TLSHandshakeTimeout(timeout))))
+
+	start := time.Now()
+	resp, err := cl.Get(context.Background(), "https://"+ln.Addr().String())
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Get() succeeded, want the handshake to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 20*timeout {
+		t.Errorf("Get() returned after %v, want about %v", elapsed, timeout)
+	}
+}
+
+func TestExpectContinueTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []TransportOption
+		want time.Duration
+	}{
+		{name: "default", want: time.Second},
+		{name: "custom", opts: []TransportOption{ExpectContinueTimeout(3 * time.Second)}, want: 3 * time.Second},
+		{name: "send body immediately", opts: []TransportOption{ExpectContinueTimeout(0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewTransportWrapper(tt.opts...).Tr.ExpectContinueTimeout; got != tt.want {
+				t.Errorf("ExpectContinueTimeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForceAttemptHTTP2(t *testing.T) {
+	srv := newH2Server(t, true)
+
+	tests := []struct {
+		force bool
+		want  string
+	}{
+		{force: true, want: "HTTP/2.0"},
+		{force: false, want: "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			// a custom TLS config disables HTTP/2 unless it is forced
+			tr := NewTransportWrapper(RootCAs(certPool(srv)), ForceAttemptHTTP2(tt.force))
+			if tr.Tr.ForceAttemptHTTP2 != tt.force {
+				t.Errorf("ForceAttemptHTTP2 = %t, want %t", tr.Tr.ForceAttemptHTTP2, tt.force)
+			}
+			cl := NewClientWrapper(Transport(tr))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Get(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if b, _ := io.ReadAll(resp.Body); string(b) != tt.want {
+				t.Errorf("negotiated %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxResponseHeaderBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Padding", strings.Repeat("x", 8<<10))
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name    string
+		opts    []TransportOption
+		wantErr bool
+	}{
+		{name: "default limit"},
+		{name: "headers over the limit", opts: []TransportOption{MaxResponseHeaderBytes(1 << 10)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(Transport(NewTransportWrapper(tt.opts...)))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Get(context.Background(), srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBufferSizes(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []TransportOption
+		write, read int
+	}{
+		{name: "go defaults when unset"},
+		{name: "write", opts: []TransportOption{WriteBufferSize(64 << 10)}, write: 64 << 10},
+		{name: "read", opts: []TransportOption{ReadBufferSize(32 << 10)}, read: 32 << 10},
+		{
+			name:  "both",
+			opts:  []TransportOption{WriteBufferSize(16 << 10), ReadBufferSize(8 << 10)},
+			write: 16 << 10,
+			read:  8 << 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTransportWrapper(tt.opts...)
+			if tr.Tr.WriteBufferSize != tt.write || tr.Tr.ReadBufferSize != tt.read {
+				t.Errorf("buffer sizes = %d, %d, want %d, %d",
+					tr.Tr.WriteBufferSize, tr.Tr.ReadBufferSize, tt.write, tt.read)
+			}
+		})
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	tr := NewTransportWrapper()
+
+	tests := []struct {
+		name string
+		opts []ClientOption
+		want *http.Transport
+	}{
+		{name: "default transport", want: http.DefaultTransport.(*http.Transport)},
+		{name: "wrapped transport", opts: []ClientOption{Transport(tr)}, want: tr.Tr},
+		{name: "behind client options", opts: []ClientOption{Transport(tr), UserAgent("test"), Retry(2, 0)}, want: tr.Tr},
+		{name: "http2 only transport", opts: []ClientOption{Transport(NewHTTP2TransportWrapper())}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewClientWrapper(tt.opts...).Transport(); got != tt.want {
+				t.Errorf("Transport() = %p, want %p", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ln := &countingListener{Listener: srv.Listener}
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	cl := NewClientWrapper(Transport(NewTransportWrapper()), UserAgent("test"))
+	get(t, cl, srv.URL)
+	if got := atomic.LoadInt64(&ln.open); got != 1 {
+		t.Fatalf("%d connections open after a request, want 1", got)
+	}
+
+	cl.CloseIdleConnections()
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&ln.open) != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("idle connection still open")
+		}
+	}
+}
+
+func TestStrictContext(t *testing.T) {
+	srv, _ := newRecordingServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		strict  bool
+		ctx     context.Context
+		wantErr error
+	}{
+		{name: "background allowed", ctx: context.Background()},
+		{name: "strict rejects background", strict: true, ctx: context.Background(), wantErr: ErrMissingContext},
+		{name: "strict rejects todo", strict: true, ctx: context.TODO(), wantErr: ErrMissingContext},
+		{name: "strict allows a caller context", strict: true, ctx: ctx},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []ClientOption
+			if tt.strict {
+				opts = append(opts, StrictContext())
+			}
+			cl := NewClientWrapper(opts...)
+
+			req, _ := http.NewRequestWithContext(tt.ctx, http.MethodGet, srv.URL, nil)
+			resp, err := cl.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetPostCanceled(t *testing.T) {
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices the client going away once the body has been read
+		io.Copy(io.Discard, r.Body)
+		received <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	cl := NewClientWrapper()
+	t.Cleanup(cl.CloseIdleConnections)
+
+	tests := []struct {
+		name string
+		send func(ctx context.Context) (*http.Response, error)
+	}{
+		{name: "get", send: func(ctx context.Context) (*http.Response, error) { return cl.Get(ctx, srv.URL) }},
+		{
+			name: "post",
+			send: func(ctx context.Context) (*http.Response, error) {
+				return cl.Post(ctx, srv.URL, "text/plain", strings.NewReader("body"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-received
+				cancel()
+			}()
+
+			resp, err := tt.send(ctx)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatal("request succeeded, want it aborted")
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("error = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestPost(t *testing.T) {
+	var gotType, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotType, gotBody = r.Header.Get("Content-Type"), string(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	resp, err := NewClientWrapper().Post(context.Background(), srv.URL, "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if gotType != "application/json" || gotBody != `{}` {
+		t.Errorf("server got %q with body %q, want application/json with {}", gotType, gotBody)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	srv, rr := newRecordingServer(t)
+
+	h := http.Header{}
+	h.Set("X-Request-Source", "examples")
+	h.Set("X-Team", "platform")
+	cl := NewClientWrapper(Headers(h))
+
+	// changes after the option is built don't leak into requests
+	h.Set("X-Team", "changed")
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	req.Header.Set("X-Request-Source", "caller")
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	got := rr.request().Header
+	want := map[string]string{"X-Request-Source": "caller", "X-Team": "platform"}
+	for k, v := range want {
+		if got.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, got.Get(k), v)
+		}
+	}
+	if req.Header.Get("X-Team") != "" {
+		t.Error("caller's request modified")
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []TransportOption
+		conns   int
+		want    int64 // connections opened
+		wantErr bool
+	}{
+		{name: "opens the connections", opts: []TransportOption{MaxIdleConsPerHost(5)}, conns: 3, want: 3},
+		{name: "capped at the idle limit", opts: []TransportOption{MaxIdleConsPerHost(3)}, conns: 5, want: 3},
+		{name: "capped at the default idle limit", conns: 5, want: http.DefaultMaxIdleConnsPerHost},
+		{name: "zero is a no-op", conns: 0, want: 0},
+		{name: "negative", conns: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// hold the warm up requests so each needs a connection of its own
+				if r.Method == http.MethodHead {
+					time.Sleep(20 * time.Millisecond)
+				}
+			}))
+			ln := &countingListener{Listener: srv.Listener}
+			srv.Listener = ln
+			srv.Start()
+			t.Cleanup(srv.Close)
+
+			cl := NewClientWrapper(Transport(NewTransportWrapper(tt.opts...)))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			err := cl.WarmUp(context.Background(), srv.URL, tt.conns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WarmUp() error = %v, want error %t", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt64(&ln.accepted); got != tt.want {
+				t.Errorf("%d connections opened, want %d", got, tt.want)
+			}
+
+			// the requests that follow reuse the warmed up connections
+			for i := 0; i < int(tt.want); i++ {
+				get(t, cl, srv.URL)
+			}
+			if got := atomic.LoadInt64(&ln.accepted); tt.want > 0 && got != tt.want {
+				t.Errorf("%d connections opened after the warm up, want the %d warmed up reused", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	srv := newRedirectServer(t)
+
+	tests := []struct {
+		name    string
+		max     int
+		hops    int
+		wantErr bool
+	}{
+		{name: "within the limit", max: 3, hops: 3},
+		{name: "over the limit", max: 3, hops: 4, wantErr: true},
+		{name: "no redirects", max: 0, hops: 0},
+		{name: "redirects forbidden", max: 0, hops: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(MaxRedirects(tt.max))
+
+			resp, err := cl.Get(context.Background(), fmt.Sprintf("%s/hop/%d", srv.URL, tt.hops))
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("stopped after %d redirects", tt.max)) {
+				t.Errorf("Get() error = %v, want it to name the limit", err)
+			}
+		})
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	// t.TempDir can exceed the length limit of socket paths on some systems
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "api.sock")
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+r.URL.Path)
+	}))
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	cl := NewClientWrapper(Transport(NewTransportWrapper(UnixSocket(path))))
+	t.Cleanup(cl.CloseIdleConnections)
+
+	// the host is only sent in the Host header, every request goes to the socket
+	for _, host := range []string{"localhost", "docker"} {
+		resp, err := cl.Get(context.Background(), "http://"+host+"/info")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if want := host + "/info"; string(b) != want {
+			t.Errorf("server got %q, want %q", b, want)
+		}
+	}
+}
+
+func TestClientWrapperClone(t *testing.T) {
+	srv, rr := newRecordingServer(t)
+
+	orig := NewClientWrapper(Transport(NewTransportWrapper()), Timeout(5*time.Second), UserAgent("orig"))
+	clone := orig.Clone(Timeout(time.Minute))
+
+	if orig.Cl.Timeout != 5*time.Second || clone.Cl.Timeout != time.Minute {
+		t.Errorf("timeouts = %v and %v, want the original's left at 5s and the clone's 1m", orig.Cl.Timeout, clone.Cl.Timeout)
+	}
+	if orig.Transport() == clone.Transport() {
+		t.Error("the clone shares the original's transport")
+	}
+
+	clone.Transport().MaxIdleConnsPerHost = 42
+	if orig.Transport().MaxIdleConnsPerHost == 42 {
+		t.Error("changing the clone's transport changed the original's")
+	}
+
+	// the wrappers of the original are reinstalled on the clone
+	get(t, clone, srv.URL)
+	if got := rr.request().UserAgent(); got != "orig" {
+		t.Errorf("clone's User-Agent = %q, want the original's", got)
+	}
+}
+
+func TestTransportWrapperClone(t *testing.T) {
+	orig := NewTransportWrapper(MaxIdleCons(10), DialTimeout(time.Second))
+	clone := orig.Clone(MaxIdleCons(50), DialTimeout(2*time.Second))
+
+	tests := []struct {
+		name      string
+		tr        *TransportWrapper
+		idle      int
+		dial      time.Duration
+		keepAlive time.Duration
+	}{
+		{name: "original", tr: orig, idle: 10, dial: time.Second, keepAlive: 30 * time.Second},
+		{name: "clone", tr: clone, idle: 50, dial: 2 * time.Second, keepAlive: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.tr.Tr.MaxIdleConns != tt.idle {
+				t.Errorf("MaxIdleConns = %d, want %d", tt.tr.Tr.MaxIdleConns, tt.idle)
+			}
+			if tt.tr.dialer.Timeout != tt.dial || tt.tr.dialer.KeepAlive != tt.keepAlive {
+				t.Errorf("dialer = %v, %v, want %v, %v", tt.tr.dialer.Timeout, tt.tr.dialer.KeepAlive, tt.dial, tt.keepAlive)
+			}
+		})
+	}
+
+	if orig.Tr == clone.Tr || orig.dialer == clone.dialer {
+		t.Error("the clone shares the original's transport or dialer")
+	}
+}
+
+func TestNewTransportWrapperStrict(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []TransportOption
+		wantErrs []string // substrings of the problems reported, none for a valid configuration
+	}{
+		{name: "defaults"},
+		{name: "valid limits", opts: []TransportOption{MaxIdleCons(10), MaxIdleConsPerHost(5), MaxConsPerHost(5)}},
+		{name: "negative keep-alive disables probes", opts: []TransportOption{KeepAlive(-1)}},
+		{
+			name:     "negative dial timeout",
+			opts:     []TransportOption{DialTimeout(-time.Second)},
+			wantErrs: []string{"dial timeout"},
+		},
+		{
+			name:     "negative header timeouts",
+			opts:     []TransportOption{ResponseHeaderTimeout(-1), TLSHandshakeTimeout(-1)},
+			wantErrs: []string{"response header timeout", "tls handshake timeout"},
+		},
+		{name: "negative size", opts: []TransportOption{ReadBufferSize(-1)}, wantErrs: []string{"read buffer size"}},
+		{
+			name:     "per host above total",
+			opts:     []TransportOption{MaxIdleCons(5), MaxIdleConsPerHost(10)},
+			wantErrs: []string{"exceeds max idle conns"},
+		},
+		{
+			name:     "idle per host above max per host",
+			opts:     []TransportOption{MaxIdleConsPerHost(10), MaxConsPerHost(4)},
+			wantErrs: []string{"exceeds max conns per host"},
+		},
+		{name: "invalid profile", opts: []TransportOption{IdleConnProfile(-1, 2)}, wantErrs: []string{"idle conn profile"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewTransportWrapperStrict(tt.opts...)
+			if len(tt.wantErrs) == 0 {
+				if err != nil || tr == nil {
+					t.Fatalf("NewTransportWrapperStrict() = %v, %v, want a transport", tr, err)
+				}
+				return
+			}
+
+			if tr != nil || !errors.Is(err, ErrInvalidTransport) {
+				t.Fatalf("NewTransportWrapperStrict() = %v, %v, want %v", tr, err, ErrInvalidTransport)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't report %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	const deadline = 50 * time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-headers":
+			select {
+			case <-time.After(4 * deadline):
+			case <-r.Context().Done():
+				return
+			}
+		case "/slow-body":
+			io.WriteString(w, "first ")
+			w.(http.Flusher).Flush()
+			time.Sleep(4 * deadline)
+		}
+		io.WriteString(w, "done")
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantBody string
+		wantErr  bool
+	}{
+		{name: "headers in time", path: "/", wantBody: "done"},
+		{name: "slow headers", path: "/slow-headers", wantErr: true},
+		{name: "slow body outlives the deadline", path: "/slow-body", wantBody: "first done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(RequestDeadline(deadline), Timeout(0))
+
+			resp, err := cl.Get(context.Background(), srv.URL+tt.path)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Get() succeeded, want the deadline to pass")
+				}
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("Get() error = %v, want %v", err, context.DeadlineExceeded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			b, err := io.ReadAll(resp.Body)
+			if err != nil || string(b) != tt.wantBody {
+				t.Errorf("body = %q, %v, want %q", b, err, tt.wantBody)
+			}
+		})
+	}
+}
+
+// stubTransport answers every request with a canned response without touching the network
+type stubTransport struct {
+	status int
+	body   string
+	calls  int64
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&s.calls, 1)
+
+	return &http.Response{
+		StatusCode: s.status,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader(s.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestRoundTripper(t *testing.T) {
+	stub := &stubTransport{status: http.StatusTeapot, body: "canned"}
+	cl := NewClientWrapper(Transport(NewTransportWrapper()), RoundTripper(stub), UserAgent("test"))
+
+	// the host doesn't exist, only the stub can answer
+	resp, err := cl.Get(context.Background(), "http://upstream.invalid/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusTeapot || string(b) != "canned" {
+		t.Errorf("got %d %q, want the canned %d %q", resp.StatusCode, b, http.StatusTeapot, "canned")
+	}
+	if resp.Request.UserAgent() != "test" {
+		t.Error("client options don't wrap the injected round tripper")
+	}
+	if stub.calls != 1 {
+		t.Errorf("stub called %d times, want 1", stub.calls)
+	}
+	if cl.Transport() != nil {
+		t.Error("Transport() returned a transport for an injected round tripper")
+	}
+}
+
+func TestUse(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				order = append(order, name+" in")
+				mu.Unlock()
+
+				resp, err := next.RoundTrip(req)
+
+				mu.Lock()
+				order = append(order, name+" out")
+				mu.Unlock()
+
+				return resp, err
+			})
+		}
+	}
+
+	cl := NewClientWrapper(
+		Use(trace("first"), trace("second")),
+		UserAgent("test"),
+		Use(trace("third")),
+		RoundTripper(NewRecordingTransport(nil)),
+	)
+	get(t, cl, "http://upstream.invalid/")
+
+	want := []string{"first in", "second in", "third in", "third out", "second out", "first out"}
+	if !slices.Equal(order, want) {
+		t.Errorf("middleware ran %v, want %v", order, want)
+	}
+}
+
+func TestIdleConnProfile(t *testing.T) {
+	tests := []struct {
+		name           string
+		total, perHost int
+		wantTotal      int // MaxIdleConns after the profile, the default 100 when it isn't applied
+		wantPerHost    int
+		wantErr        bool
+	}{
+		{name: "sets both", total: 50, perHost: 10, wantTotal: 50, wantPerHost: 10},
+		{name: "per host equal to total", total: 8, perHost: 8, wantTotal: 8, wantPerHost: 8},
+		{name: "unlimited total", total: 0, perHost: 20, wantTotal: 0, wantPerHost: 20},
+		{name: "per host above total", total: 5, perHost: 10, wantTotal: 100, wantErr: true},
+		{name: "negative", total: -1, perHost: 2, wantTotal: 100, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTransportWrapper(IdleConnProfile(tt.total, tt.perHost))
+			if tr.Tr.MaxIdleConns != tt.wantTotal || tr.Tr.MaxIdleConnsPerHost != tt.wantPerHost {
+				t.Errorf("idle limits = %d, %d, want %d, %d",
+					tr.Tr.MaxIdleConns, tr.Tr.MaxIdleConnsPerHost, tt.wantTotal, tt.wantPerHost)
+			}
+
+			_, err := NewTransportWrapperStrict(IdleConnProfile(tt.total, tt.perHost))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTransportWrapperStrict() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		allow   []string
+		wantErr error
+	}{
+		{name: "https", url: "https://upstream.invalid/"},
+		{name: "http rejected", url: "http://upstream.invalid/", wantErr: ErrInsecureScheme},
+		{name: "allowed host", url: "http://localhost:8080/", allow: []string{"localhost"}},
+		{name: "allowed host any case", url: "http://LocalHost/", allow: []string{"localhost"}},
+		{
+			name:    "other host rejected",
+			url:     "http://upstream.invalid/",
+			allow:   []string{"localhost"},
+			wantErr: ErrInsecureScheme,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubTransport{status: http.StatusOK}
+			cl := NewClientWrapper(RoundTripper(stub), RequireHTTPS(tt.allow...))
+
+			resp, err := cl.Get(context.Background(), tt.url)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			wantCalls := int64(1)
+			if tt.wantErr != nil {
+				wantCalls = 0 // rejected before reaching the transport
+			}
+			if stub.calls != wantCalls {
+				t.Errorf("transport called %d times, want %d", stub.calls, wantCalls)
+			}
+		})
+	}
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ClientOption
+		want time.Duration
+	}{
+		{name: "default", want: DefaultTimeout},
+		{name: "custom", opts: []ClientOption{Timeout(time.Minute)}, want: time.Minute},
+		{name: "disabled", opts: []ClientOption{Timeout(0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(tt.opts...)
+			if cl.Cl.Timeout != tt.want {
+				t.Errorf("Timeout = %v, want %v", cl.Cl.Timeout, tt.want)
+			}
+		})
+	}
+
+	t.Run("enforced", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		t.Cleanup(srv.Close)
+		t.Cleanup(func() { close(release) })
+
+		cl := NewClientWrapper(Timeout(50 * time.Millisecond))
+		_, err := cl.Get(context.Background(), srv.URL)
+
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("Get() against a hung upstream error = %v, want a timeout", err)
+		}
+	})
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	// newServer starts a TLS server offering only the versions between lo and hi
+	newServer := func(lo, hi uint16) *httptest.Server {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, tls.VersionName(r.TLS.Version))
+		}))
+		srv.TLS = &tls.Config{MinVersion: lo, MaxVersion: hi}
+		srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+
+		return srv
+	}
+	legacy := newServer(tls.VersionTLS10, tls.VersionTLS11)
+	modern := newServer(tls.VersionTLS12, tls.VersionTLS12)
+
+	tests := []struct {
+		name string
+		srv  *httptest.Server
+		min  uint16
+		want string // negotiated version, empty when the handshake fails
+	}{
+		{name: "legacy server rejected", srv: legacy, min: tls.VersionTLS12},
+		{name: "legacy server allowed", srv: legacy, min: tls.VersionTLS10, want: "TLS 1.1"},
+		{name: "minimum met", srv: modern, min: tls.VersionTLS12, want: "TLS 1.2"},
+		{name: "minimum above the server", srv: modern, min: tls.VersionTLS13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTransportWrapper(RootCAs(certPool(tt.srv)), MinTLSVersion(tt.min))
+			cl := NewClientWrapper(Transport(tr))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Get(context.Background(), tt.srv.URL)
+			if tt.want == "" {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Get() succeeded below the minimum TLS version")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if b, _ := io.ReadAll(resp.Body); string(b) != tt.want {
+				t.Errorf("negotiated %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name     string
+		opts     []TransportOption
+		wantHost string
+	}{
+		{name: "system picked", wantHost: "127.0.0.1"},
+		{
+			name:     "configured address",
+			opts:     []TransportOption{LocalAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.2")})},
+			wantHost: "127.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewClientWrapper(Transport(NewTransportWrapper(tt.opts...)))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Get(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			b, _ := io.ReadAll(resp.Body)
+			host, _, err := net.SplitHostPort(string(b))
+			if err != nil {
+				t.Fatalf("server saw remote address %q: %v", b, err)
+			}
+			if host != tt.wantHost {
+				t.Errorf("connection came from %s, want %s", host, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestRequestHook(t *testing.T) {
+	type tenantKey struct{}
+
+	srv, rr := newRecordingServer(t)
+	var order []string
+	cl := NewClientWrapper(
+		RequestHook(func(req *http.Request) *http.Request {
+			order = append(order, "first")
+			req.Header.Set("X-Tenant", req.Context().Value(tenantKey{}).(string))
+			req.Header.Set("X-Seen-User-Agent", req.UserAgent())
+			return req
+		}),
+		RequestHook(func(req *http.Request) *http.Request {
+			order = append(order, "second")
+			req.Header.Set("X-Tenant", req.Header.Get("X-Tenant")+"-eu")
+			return req
+		}),
+		UserAgent("test"), // added after the hooks, still applied before they run
+	)
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	got := rr.request().Header
+	if got.Get("X-Tenant") != "acme-eu" {
+		t.Errorf("X-Tenant = %q, want %q set from the context by both hooks", got.Get("X-Tenant"), "acme-eu")
+	}
+	if got.Get("X-Seen-User-Agent") != "test" {
+		t.Error("the hook didn't see the request after the other client options")
+	}
+	if !slices.Equal(order, []string{"first", "second"}) {
+		t.Errorf("hooks ran %v, want in the order they were added", order)
+	}
+	if req.Header.Get("X-Tenant") != "" {
+		t.Error("the hook modified the caller's request")
+	}
+}
+
+// newSelfSignedServer starts a TLS server with a freshly generated self signed certificate for 127.0.0.1, so its key
+// differs from the one every httptest server shares, it is closed when the test ends
+func newSelfSignedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating a key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "self signed"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating a certificate: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.DidResume)
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestPinCertificates(t *testing.T) {
+	pinned := newSelfSignedServer(t)
+	other := newSelfSignedServer(t)
+	pin := CertificatePin(pinned.Certificate())
+
+	tests := []struct {
+		name    string
+		srv     *httptest.Server
+		pins    [][]byte
+		wantErr error
+	}{
+		{name: "pinned key", srv: pinned, pins: [][]byte{pin}},
+		{name: "one of several pins", srv: pinned, pins: [][]byte{CertificatePin(other.Certificate()), pin}},
+		{name: "unpinned key", srv: other, pins: [][]byte{pin}, wantErr: ErrCertificatePin},
+		{name: "no pins", srv: pinned, wantErr: ErrCertificatePin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTransportWrapper(RootCAs(certPool(pinned, other)), PinCertificates(tt.pins...))
+			tr.Tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+			cl := NewClientWrapper(Transport(tr))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			// the second request resumes the TLS session of the first on a new connection, the pin is checked again
+			for _, wantResumed := range []string{"false", "true"} {
+				resp, err := cl.Get(context.Background(), tt.srv.URL)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				b, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if string(b) != wantResumed {
+					t.Errorf("session resumed %s, want %s", b, wantResumed)
+				}
+				cl.CloseIdleConnections()
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package patterns
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Hedge cuts tail latency by sending a second copy of a GET request when the first hasn't responded within delay,
+// whichever responds first is returned and the other is cancelled.  Only GET requests without a body are hedged since
+// they are safe to send twice, other requests are passed through untouched.  A failed attempt doesn't end the request
+// while the other attempt is still in flight.  Hedging doubles the load of slow requests on the upstream, pick a delay
+// around the high percentiles of its latency.
+func Hedge(delay time.Duration) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if req.Method != http.MethodGet || (req.Body != nil && req.Body != http.NoBody) {
+					return next.RoundTrip(req)
+				}
+
+				return hedge(next, req, delay)
+			})
+		})
+	}
+}
+
+// hedgeResult is the outcome of one attempt of a hedged request
+type hedgeResult struct {
+	attempt int
+	resp    *http.Response
+	err     error
+}
+
+// hedge sends req, and a second copy of it if there's no response within delay, returning the first response
+func hedge(next http.RoundTripper, req *http.Request, delay time.Duration) (*http.Response, error) {
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+
+	send := func() {
+		ctx, cancel := context.WithCancel(req.Context())
+		attempt := len(cancels)
+		cancels = append(cancels, cancel)
+
+		go func() {
+			resp, err := next.RoundTrip(req.Clone(ctx))
+			results <- hedgeResult{attempt: attempt, resp: resp, err: err}
+		}()
+	}
+
+	send()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var err error
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			send()
+			pending++
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				cancels[res.attempt]()
+				err = res.err
+				continue
+			}
+
+			// cancel and clean up the attempt still in flight, the winner's context lives on with its body
+			for i, cancel := range cancels {
+				if i != res.attempt {
+					cancel()
+				}
+			}
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if loser := <-results; loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+				}
+			}(pending)
+
+			res.resp.Body = readCloser{
+				Reader: res.resp.Body,
+				Closer: cancelCloser{Closer: res.resp.Body, cancel: cancels[res.attempt]},
+			}
+
+			return res.resp, nil
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,78 @@
+package patterns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedge(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		method    string
+		slowFirst bool
+		wantBody  string
+		wantCalls int64
+	}{
+		{name: "slow first attempt is hedged", method: http.MethodGet, slowFirst: true, wantBody: "2", wantCalls: 2},
+		{name: "fast response isn't hedged", method: http.MethodGet, wantBody: "1", wantCalls: 1},
+		{name: "post isn't hedged", method: http.MethodPost, slowFirst: true, wantBody: "1", wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int64
+			cancelled := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt64(&calls, 1)
+				if n == 1 && tt.slowFirst {
+					select {
+					case <-r.Context().Done():
+						close(cancelled)
+						return
+					case <-time.After(10 * delay):
+					}
+				}
+				fmt.Fprint(w, n)
+			}))
+			t.Cleanup(srv.Close)
+
+			cl := NewClientWrapper(Hedge(delay))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			var body io.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader("payload")
+			}
+			req, _ := http.NewRequestWithContext(context.Background(), tt.method, srv.URL, body)
+			resp, err := cl.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if string(b) != tt.wantBody {
+				t.Errorf("response from attempt %s, want %s", b, tt.wantBody)
+			}
+			if got := atomic.LoadInt64(&calls); got != tt.wantCalls {
+				t.Errorf("%d attempts, want %d", got, tt.wantCalls)
+			}
+			if tt.wantCalls == 2 {
+				select {
+				case <-cancelled:
+				case <-time.After(5 * time.Second):
+					t.Error("the losing attempt wasn't cancelled")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package patterns
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// NewHTTP2TransportWrapper creates a transport that only speaks HTTP/2, for gRPC style and strict HTTP/2 APIs where
+// falling back to HTTP/1.1 would be a bug.  Connections are opened with prior knowledge rather than negotiated through
+// an upgrade, https:// URLs must agree on h2 through ALPN and http:// URLs are refused unless H2C is set.
+//
+// The options are applied to Tr as usual and copied onto H2 where HTTP/2 has an equivalent: the dialer, TLS, idle
+// timeout, compression, and header size options carry over, options about the HTTP/1.1 connection pool, proxies, and
+// buffer sizes have no effect.
+func NewHTTP2TransportWrapper(opts ...TransportOption) *TransportWrapper {
+	tr := NewTransportWrapper(opts...)
+	tr.configureHTTP2()
+
+	return tr
+}
+
+// configureHTTP2 builds H2 from the options applied to Tr, and the cleartext transport for http:// URLs when H2C is set.
+// The http2 package dials every connection through DialTLSContext whatever the scheme, so cleartext connections get a
+// transport of their own, and a pool of their own, rather than a dialer that would have to guess the scheme.
+func (t *TransportWrapper) configureHTTP2() {
+	dial := t.Tr.DialContext
+	handshakeTimeout := t.Tr.TLSHandshakeTimeout
+
+	t.H2 = t.newHTTP2(false)
+	t.H2.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if handshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, handshakeTimeout)
+			defer cancel()
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		// the http2 package only checks ALPN when it dials itself, a server that didn't agree on h2 would be spoken
+		// HTTP/2 to regardless
+		if p := tlsConn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+			tlsConn.Close()
+			return nil, fmt.Errorf("http2: server negotiated protocol %q instead of %q", p, http2.NextProtoTLS)
+		}
+
+		return tlsConn, nil
+	}
+
+	t.h2Clear = nil
+	if t.h2c {
+		t.h2Clear = t.newHTTP2(true)
+		t.h2Clear.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		}
+	}
+}
+
+// newHTTP2 returns an HTTP/2 transport with the options applied to Tr that have an HTTP/2 equivalent
+func (t *TransportWrapper) newHTTP2(allowHTTP bool) *http2.Transport {
+	h2 := &http2.Transport{
+		TLSClientConfig:    t.Tr.TLSClientConfig,
+		DisableCompression: t.Tr.DisableCompression,
+		AllowHTTP:          allowHTTP,
+		IdleConnTimeout:    t.Tr.IdleConnTimeout,
+	}
+	if t.Tr.MaxResponseHeaderBytes > 0 {
+		h2.MaxHeaderListSize = uint32(t.Tr.MaxResponseHeaderBytes)
+	}
+
+	return h2
+}
+
+// h2RoundTripper returns the round tripper the Transport client option sends requests through, H2 unless H2C is set
+func (t *TransportWrapper) h2RoundTripper() http.RoundTripper {
+	if t.h2Clear == nil {
+		return t.H2
+	}
+
+	return &h2Transports{secure: t.H2, clear: t.h2Clear}
+}
+
+// h2Transports sends http:// requests over cleartext HTTP/2 and every other request over HTTP/2 on TLS
+type h2Transports struct {
+	secure *http2.Transport
+	clear  *http2.Transport
+}
+
+func (t *h2Transports) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.clear.RoundTrip(req)
+	}
+
+	return t.secure.RoundTrip(req)
+}
+
+func (t *h2Transports) CloseIdleConnections() {
+	t.secure.CloseIdleConnections()
+	t.clear.CloseIdleConnections()
+}
+
+// H2C allows NewHTTP2TransportWrapper to speak cleartext HTTP/2 to http:// URLs, for HTTP/2 servers behind a TLS
+// terminating proxy.  https:// URLs still use TLS.  It has no effect on transports made by NewTransportWrapper.
+func H2C() TransportOption {
+	return func(t *TransportWrapper) {
+		t.h2c = true
+	}
+}
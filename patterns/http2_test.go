@@ -0,0 +1,92 @@
+package patterns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newH2Server starts an HTTP/2 server over TLS, or over cleartext when tls is false, reporting the protocol of each
+// request it serves
+func newH2Server(t *testing.T, tls bool) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	if tls {
+		srv.EnableHTTP2 = true
+		srv.StartTLS()
+	} else {
+		srv.Config.Protocols = new(http.Protocols)
+		srv.Config.Protocols.SetUnencryptedHTTP2(true)
+		srv.Start()
+	}
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// certPool returns a pool trusting the certificates of the given TLS test servers
+func certPool(servers ...*httptest.Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, srv := range servers {
+		pool.AddCert(srv.Certificate())
+	}
+
+	return pool
+}
+
+func TestNewHTTP2TransportWrapper(t *testing.T) {
+	secure := newH2Server(t, true)
+	clear := newH2Server(t, false)
+
+	// a server without any ALPN protocols ignores the client's, the handshake succeeds without agreeing on h2
+	h1 := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h1.TLS = &tls.Config{NextProtos: []string{}}
+	h1.Config.ErrorLog = log.New(io.Discard, "", 0)
+	h1.StartTLS()
+	t.Cleanup(h1.Close)
+
+	tests := []struct {
+		name    string
+		opts    []TransportOption
+		url     string
+		wantErr bool
+	}{
+		{name: "https", url: secure.URL},
+		{name: "https with h2c still uses tls", opts: []TransportOption{H2C()}, url: secure.URL},
+		{name: "http with h2c", opts: []TransportOption{H2C()}, url: clear.URL},
+		{name: "http without h2c is refused", url: clear.URL, wantErr: true},
+		{name: "server without h2 alpn is refused", url: h1.URL, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]TransportOption{RootCAs(certPool(secure, h1))}, tt.opts...)
+			cl := NewClientWrapper(Transport(NewHTTP2TransportWrapper(opts...)))
+			t.Cleanup(cl.CloseIdleConnections)
+
+			resp, err := cl.Cl.Get(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "HTTP/2.0" {
+				t.Errorf("server saw %s, want HTTP/2.0", body)
+			}
+		})
+	}
+}
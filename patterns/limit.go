@@ -0,0 +1,56 @@
+package patterns
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ErrBodyTooLarge is returned reading a response body longer than the limit set by MaxResponseBody
+var ErrBodyTooLarge = errors.New("response body exceeds the size limit")
+
+// MaxResponseBody caps response bodies at n bytes, protecting against running out of memory reading the response of a
+// malicious or buggy server.  Reading past n bytes fails with ErrBodyTooLarge rather than silently truncating the body,
+// the first n bytes are still delivered.
+func MaxResponseBody(n int64) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				resp, err := next.RoundTrip(req)
+				if err != nil {
+					return nil, err
+				}
+
+				resp.Body = &limitedBody{ReadCloser: resp.Body, left: n}
+
+				return resp, nil
+			})
+		})
+	}
+}
+
+// limitedBody fails reads once more than its limit has been read
+type limitedBody struct {
+	io.ReadCloser
+	left int64 // bytes left before the limit, negative once it has been exceeded
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	// read one byte past the limit to tell a body of exactly the limit from a longer one
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.left {
+		n, b.left = int(b.left), -1
+		return n, ErrBodyTooLarge
+	}
+	b.left -= int64(n)
+
+	return n, err
+}
@@ -0,0 +1,68 @@
+package patterns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxResponseBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		limit   int64
+		wantErr error
+	}{
+		{name: "under the limit", size: 10, limit: 16},
+		{name: "exactly the limit", size: 16, limit: 16},
+		{name: "one byte over", size: 17, limit: 16, wantErr: ErrBodyTooLarge},
+		{name: "streamed far over", size: 1 << 20, limit: 1024, wantErr: ErrBodyTooLarge},
+		{name: "empty body", size: 0, limit: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// stream in chunks without a Content-Length so the limit applies to what is actually read
+				chunk := strings.Repeat("x", 4096)
+				for left := tt.size; left > 0; left -= len(chunk) {
+					if left < len(chunk) {
+						chunk = chunk[:left]
+					}
+					io.WriteString(w, chunk)
+					w.(http.Flusher).Flush()
+				}
+			}))
+			t.Cleanup(srv.Close)
+
+			cl := NewClientWrapper(MaxResponseBody(tt.limit))
+			resp, err := cl.Get(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			b, err := io.ReadAll(resp.Body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reading the body error = %v, want %v", err, tt.wantErr)
+			}
+
+			// the body is delivered up to the limit, never truncated silently
+			want := min(int64(tt.size), tt.limit)
+			if int64(len(b)) != want {
+				t.Errorf("read %d bytes, want %d", len(b), want)
+			}
+
+			// the error sticks once the limit has been exceeded
+			if tt.wantErr != nil {
+				if _, err := resp.Body.Read(make([]byte, 1)); !errors.Is(err, ErrBodyTooLarge) {
+					t.Errorf("read after the limit error = %v, want %v", err, ErrBodyTooLarge)
+				}
+			}
+		})
+	}
+}
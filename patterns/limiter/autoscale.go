@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// sustained is the number of consecutive polls the queue depth must stay above the threshold, or stay empty, before the
+// autoscaler resizes the worker pool.  This keeps short bursts from resizing the pool.
+const sustained = 3
+
+// autoscaling holds the autoscaler settings
+type autoscaling struct {
+	min       int32         // smallest pool size the autoscaler shrinks to
+	max       int32         // largest pool size the autoscaler grows to
+	threshold int           // queue depth above which the pool grows
+	interval  time.Duration // how often the queue depth is polled
+
+	idleTimeout time.Duration // how long a worker waits for a job before exiting, zero keeps idle workers
+}
+
+// autoscale grows and shrinks the worker pool based on the queue depth until the root context is done, it returns
+// immediately when the controller was created without autoscaling.
+func (c *controller) autoscale() {
+	if c.scale.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.scale.interval)
+	defer ticker.Stop()
+
+	var busy, idle int // consecutive polls above the threshold and with an empty queue
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		// a paused pool neither drains the queue nor picks up remove signals, scaling it would only skew the counts
+		running := c.running && !c.draining && !c.paused
+		c.mu.Unlock()
+		if !running {
+			busy, idle = 0, 0
+			continue
+		}
+
+		switch depth := c.depth(); {
+		case depth > c.scale.threshold:
+			busy, idle = busy+1, 0
+		case depth == 0:
+			busy, idle = 0, idle+1
+		default:
+			busy, idle = 0, 0
+		}
+
+		if busy >= sustained {
+			c.mu.Lock()
+			if n := atomic.LoadInt32(&c.workers); n < c.scale.max && (c.maxWorkers <= 0 || int(n) < c.maxWorkers) {
+				c.spawnWorker()
+				c.logger.Info("autoscaler added worker", "workers", atomic.LoadInt32(&c.workers))
+			}
+			c.mu.Unlock()
+			busy = 0
+		}
+
+		if idle >= sustained {
+			// idle workers pick up the signal right away, don't hold up the autoscaler if they are all busy
+			removeCtx, cancel := context.WithTimeout(c.ctx, c.scale.interval)
+			if err := c.signalRemove(removeCtx, c.minWorkers()); err == nil {
+				c.logger.Info("autoscaler removed worker")
+			}
+			cancel()
+			idle = 0
+		}
+	}
+}
+
+// minWorkers returns the smallest size the worker pool is shrunk to, the autoscaler's min but never below one worker
+// so the queue is always consumed
+func (c *controller) minWorkers() int32 {
+	if c.scale.min < 1 {
+		return 1
+	}
+
+	return c.scale.min
+}
+
+// checkScaling disables autoscaling when the pool can't be kept between min and max workers
+func (c *controller) checkScaling() {
+	if c.scale.interval <= 0 || c.minWorkers() <= c.scale.max {
+		return
+	}
+
+	c.logger.Error("autoscaling disabled, min workers exceeds max workers", "min", c.minWorkers(), "max", c.scale.max)
+	c.scale.interval = 0
+}
+
+// withIdleTimeout reclaims workers that receive no job for d, idle workers exit until the pool is down to the
+// autoscaler's min size, or a single worker without autoscaling
+func withIdleTimeout(d time.Duration) controllerOption {
+	return func(c *controller) {
+		c.scale.idleTimeout = d
+	}
+}
+
+// idleTimer returns the channel an idle worker's timeout fires on and a func to stop the timer, the channel is nil
+// when idle workers are kept
+func (c *controller) idleTimer() (<-chan time.Time, func() bool) {
+	if c.scale.idleTimeout <= 0 {
+		return nil, func() bool { return false }
+	}
+
+	t := time.NewTimer(c.scale.idleTimeout)
+
+	return t.C, t.Stop
+}
+
+// retireIdle reports whether an idle worker may exit without taking the pool below its minimum size.  The exit is
+// counted as a pending removal so concurrent idle workers can't all leave at once, the worker calls retired once it
+// is no longer counted as live.
+func (c *controller) retireIdle() bool {
+	min := c.minWorkers()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if atomic.LoadInt32(&c.workers)-c.removing <= min {
+		return false
+	}
+	c.removing++
+
+	return true
+}
+
+// retired clears the pending removal of a worker that exited through retireIdle
+func (c *controller) retired() {
+	c.mu.Lock()
+	c.removing--
+	c.mu.Unlock()
+}
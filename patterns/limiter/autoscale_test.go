@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAutoscaleMinWorkers(t *testing.T) {
+	tests := []struct {
+		name string
+		min  int
+		want int32
+	}{
+		{name: "zero keeps one worker", min: 0, want: 1},
+		{name: "negative keeps one worker", min: -2, want: 1},
+		{name: "shrinks to min", min: 2, want: 2},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 10, 3, withAutoscaling(tt.min, 4, 5, 5*time.Millisecond))
+			c.wgroup()
+			go c.autoscale()
+
+			waitFor(t, "the pool to shrink", func() bool { return atomic.LoadInt32(&c.workers) == tt.want })
+
+			// give the autoscaler time for several more rounds with an empty queue
+			time.Sleep(20 * c.scale.interval)
+			if got := atomic.LoadInt32(&c.workers); got != tt.want {
+				t.Errorf("workers = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoscalingMinAboveMax(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 1, withAutoscaling(3, 2, 5, time.Millisecond))
+	if c.scale.interval != 0 {
+		t.Errorf("autoscaling enabled with min 3 above max 2")
+	}
+}
+
+func TestWithIdleTimeout(t *testing.T) {
+	const (
+		workers = 4
+		timeout = 20 * time.Millisecond
+	)
+
+	tests := []struct {
+		name string
+		opts []controllerOption
+		want int32
+	}{
+		{name: "single worker kept", opts: []controllerOption{withIdleTimeout(timeout)}, want: 1},
+		{
+			name: "down to the autoscaler's min",
+			opts: []controllerOption{withIdleTimeout(timeout), withAutoscaling(2, workers, 5, time.Hour)},
+			want: 2,
+		},
+		{name: "idle workers kept without a timeout", want: workers},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 10, workers, tt.opts...)
+			c.wgroup()
+
+			for i := 1; i <= 10; i++ {
+				if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("submit() error = %v", err)
+				}
+			}
+
+			// the queue is no longer fed, workers above the minimum receive no job and exit
+			waitFor(t, "the idle workers to exit", func() bool { return atomic.LoadInt32(&c.workers) == tt.want })
+			time.Sleep(5 * timeout)
+			if got := atomic.LoadInt32(&c.workers); got != tt.want {
+				t.Errorf("workers = %d after sitting idle, want %d", got, tt.want)
+			}
+			if s := c.Stats(); s.Processed != 10 {
+				t.Errorf("processed %d jobs, want 10", s.Processed)
+			}
+		})
+	}
+}
+
+func TestAutoscaleGrowth(t *testing.T) {
+	const (
+		minPool, maxPool = 1, 4
+		jobs             = 50
+	)
+
+	// the jobs hold the workers until released, the queue stays deep however many workers there are
+	release := make(chan struct{})
+	slow := func(ctx context.Context, job Job) error {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), jobs, minPool, withRequestFunc(slow),
+		withAutoscaling(minPool, maxPool, 2, 5*time.Millisecond))
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+	go c.autoscale()
+
+	waitFor(t, "the pool to grow to max", func() bool { return atomic.LoadInt32(&c.workers) == maxPool })
+
+	// the pool stays at max while the queue is still deep
+	time.Sleep(20 * c.scale.interval)
+	if got := atomic.LoadInt32(&c.workers); got != maxPool {
+		t.Errorf("workers = %d with a deep queue, want %d", got, maxPool)
+	}
+
+	close(release)
+	waitFor(t, "the queue to empty", func() bool { return c.Stats().Processed == jobs })
+	waitFor(t, "the pool to shrink back to min", func() bool { return atomic.LoadInt32(&c.workers) == minPool })
+}
@@ -11,48 +11,382 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-
+	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 
 	"examples/patterns"
 )
 
-//  channels and waitgroup must be included in the controller struct to be able to stop, start, and update
+// Job is a single unit of work processed by the worker pool
+type Job struct {
+	ID  int             // identifies the job in logs
+	URL string          // target of the request, when empty the job's path is resolved against the controller's target
+	Ctx context.Context // optional, cancels the request when done, the root context cancels the request either way
+
+	Priority int // jobs with a higher priority are processed first by the priority controller
+	Weight   int // concurrency slots the job occupies when the controller has a capacity, zero counts as one
+
+	Path string // path of the request below the controller's target, used when URL is empty
+
+	seq uint64 // identifies the job in the job store, zero when the job isn't stored
+}
+
+// Result is the outcome of processing a Job
+type Result struct {
+	JobID  int   // ID of the job that produced the result
+	Status int   // http status code of the response, zero if no response was received
+	Err    error // non nil if the request failed
+}
+
+// RequestFunc does the work for a single job, a non nil error marks the job as failed
+type RequestFunc func(ctx context.Context, job Job) error
+
+// channels and waitgroup must be included in the controller struct to be able to stop, start, and update
 type controller struct {
-	queue chan int                // job queue
-	done  chan struct{}           // channel to signal workers to stop processing requests
-	cl    *patterns.ClientWrapper // http.client
-	limit *sync.WaitGroup         // anytime a waitgroup is added to a controller struct it needs to be a pointer
+	// 64 bit counters are kept first so they are 64 bit aligned for atomic access on 32 bit platforms
+	deadN     int64 // number of jobs sent to the dead letter queue, must be accessed atomically
+	processed int64 // number of jobs processed, successful or not, must be accessed atomically
+	failed    int64 // number of jobs that failed, must be accessed atomically
+	inFlight  int64 // number of jobs currently being processed, must be accessed atomically
+	nextID    int64 // last worker id handed out, must be accessed atomically
+	connErrs  int64 // consecutive jobs that failed without a response, must be accessed atomically
+	jobID     int64 // last job id handed out, must be accessed atomically
+	limited   int64 // jobs completed against the job limit, must be accessed atomically
+	budget    int64 // jobs left under the job limit that no worker has reserved, must be accessed atomically
+
+	queue      chan Job                // job queue, unbuffered and fed by dispatch when the controller has an ordered queue
+	results    chan Result             // outcome of each processed job, dropped when the consumer doesn't keep up
+	dead       chan Job                // dead letter queue of failed jobs, available for reprocessing
+	ctx        context.Context         // root context, cancelling it shuts down the workers, the server, and the producer
+	pool       context.Context         // context of the current worker pool, cancelled to signal workers to stop
+	stopPool   context.CancelFunc      // cancels pool
+	remove     chan struct{}           // channel to signal a single worker to stop processing requests
+	cl         *patterns.ClientWrapper // http.client
+	target     string                  // base url the paths of jobs without a URL are resolved against
+	ln         net.Listener            // listener the control server is served on, nil listens on controlAddr
+	limit      *sync.WaitGroup         // anytime a waitgroup is added to a controller struct it needs to be a pointer
+	mu         *sync.Mutex             // guards pool, stopPool, removing, running, and draining
+	workers    int32                   // number of live workers, must be accessed atomically
+	removing   int32                   // number of workers signaled to stop that have not yet picked up the signal
+	running    bool                    // true while the worker pool is consuming from the work queue
+	draining   bool                    // true while the worker pool is finishing off the work queue before stopping
+	size       int                     // number of workers started by wgroup
+	maxWorkers int                     // largest size the worker pool can be grown to, zero means no limit
+	logger     *slog.Logger            // structured logger for worker lifecycle, job outcomes, and state transitions
+	work       RequestFunc             // work done for each job, the default GETs the job's URL
+	genID      func() int              // generates the ids of jobs created by the controller, the default is sequential
+	limiter    *rate.Limiter           // caps the rate requests are issued at across the whole worker pool
+	highMark   int                     // queue depth above which a backpressure warning is logged, zero disables the warning
+	high       int32                   // 1 while the queue depth is above highMark, must be accessed atomically
+	scale      autoscaling             // autoscaler settings, the autoscaler is disabled when the interval is zero
+
+	jobTimeout         time.Duration // maximum time a single job may take, zero means no limit
+	deadLetterTimeouts bool          // send jobs that exceed jobTimeout to the dead letter queue
+	stagger            time.Duration // longest random delay between worker starts in wgroup, zero starts them at once
+
+	resetAfter int64 // consecutive connection errors after which idle connections are closed, zero disables the reset
+
+	newClient func() *patterns.ClientWrapper // builds a client for each worker, nil when every worker shares cl
+	store     JobStore                       // persists queued jobs across restarts, nil keeps them in memory only
+	pending   *pendingJobs                   // stored jobs that haven't been processed yet, nil without a store
+
+	slots    *semaphore.Weighted // concurrency slots shared by the in flight jobs, nil when the capacity is unlimited
+	capacity int64               // total weight of the jobs allowed in flight at once
+
+	// the controller owns closing the queue, senders hold a read lock so the queue is never closed under them
+	sendMu    *sync.RWMutex
+	closing   chan struct{} // closed when the queue starts closing, wakes senders blocked on a full queue
+	closeOnce *sync.Once
+
+	maxJobs     int64         // number of jobs processed before the pool stops itself, zero means no limit
+	budgetFreed chan struct{} // signaled when a worker hands back an unused reservation from the budget
+	finished    chan struct{} // closed once the job limit is reached and the workers have stopped, nil without a limit
+
+	paused   bool          // true while the workers are held idle by pause, guarded by mu
+	pausing  chan struct{} // closed when the pool is paused to wake idle workers, replaced on resume, guarded by mu
+	unpaused chan struct{} // closed on resume to release the parked workers, replaced on pause, guarded by mu
+
+	// the counters are updated atomically while holding a read lock, Stats takes the write lock to read them all at once
+	statsMu *sync.RWMutex
+
+	// processed and failed as of the last ResetStats, the counters themselves never go down so prometheus sees them as
+	// the monotonic totals they are, guarded by statsMu
+	resetProcessed int64
+	resetFailed    int64
+
+	registry *prometheus.Registry // prometheus collectors owned by this controller
+	latency  prometheus.Histogram // latency of requests issued by the worker pool
+
+	broker *eventBroker // streams job completions to the subscribers of the events endpoint
+
+	ordered *orderedQueue // hands out jobs in an order of its own through the work queue, nil for first in first out
+}
+
+// controllerOption configures optional controller behavior using the same functional options pattern as the patterns
+// package
+type controllerOption func(c *controller)
+
+// withRateLimit caps the number of requests per second issued by the whole worker pool, independent of the number of
+// workers.  burst is the number of requests allowed to be issued at once.  The default is no limit, a limit of zero or
+// less is ignored since it would hold up the workers forever.
+func withRateLimit(limit rate.Limit, burst int) controllerOption {
+	return func(c *controller) {
+		if limit <= 0 {
+			return
+		}
+
+		c.limiter.SetLimit(limit)
+		c.limiter.SetBurst(burst)
+	}
+}
+
+// withHighWaterMark logs a backpressure warning each time the queue depth rises above depth
+func withHighWaterMark(depth int) controllerOption {
+	return func(c *controller) {
+		c.highMark = depth
+	}
+}
+
+// withLogger sets the structured logger used by the controller, the default is slog.Default
+func withLogger(logger *slog.Logger) controllerOption {
+	return func(c *controller) {
+		c.logger = logger
+	}
+}
+
+// withRequestFunc replaces the default GET request with fn so the worker pool can run any kind of work
+func withRequestFunc(fn RequestFunc) controllerOption {
+	return func(c *controller) {
+		c.work = fn
+	}
+}
+
+// withJobTimeout aborts jobs that take longer than d, timed out jobs count as failures and are only sent to the dead
+// letter queue when deadLetter is true
+func withJobTimeout(d time.Duration, deadLetter bool) controllerOption {
+	return func(c *controller) {
+		c.jobTimeout = d
+		c.deadLetterTimeouts = deadLetter
+	}
+}
+
+// withIdleReset closes the client's idle connections after n consecutive jobs fail without receiving a response, so the
+// pool recovers from connections that went stale, for example after a network partition, without a restart
+func withIdleReset(n int) controllerOption {
+	return func(c *controller) {
+		c.resetAfter = int64(n)
+	}
+}
+
+// withCapacity limits the total weight of the jobs in flight at once to capacity, a job of weight 3 occupies three
+// slots.  Heavy jobs can't overwhelm the upstream however many workers there are, workers wait for enough slots to free
+// up before issuing a job.  A capacity of zero or less leaves the capacity unlimited.
+func withCapacity(capacity int) controllerOption {
+	return func(c *controller) {
+		if capacity <= 0 {
+			c.capacity, c.slots = 0, nil
+			return
+		}
+
+		c.capacity = int64(capacity)
+		c.slots = semaphore.NewWeighted(c.capacity)
+	}
+}
+
+// withStagger spreads out the start of the initial workers by a random delay of up to d between each, so a cold
+// upstream isn't hit by the whole pool at once
+func withStagger(d time.Duration) controllerOption {
+	return func(c *controller) {
+		c.stagger = d
+	}
+}
+
+// controlAddr is the address the control server listens on unless withListener sets a listener
+const controlAddr = ":4000"
+
+// withListener serves the control endpoints on ln instead of listening on controlAddr, run closes ln on shutdown
+func withListener(ln net.Listener) controllerOption {
+	return func(c *controller) {
+		c.ln = ln
+	}
+}
+
+// withTarget points the worker pool at the upstream at base, jobs without a URL request their path below it
+func withTarget(base string) controllerOption {
+	return func(c *controller) {
+		c.target = base
+	}
+}
+
+// withJobIDGenerator sets the generator of the ids of jobs created by the controller, such as ingested jobs that don't
+// carry an id, e.g. snowflakeIDs for ids that stay unique across restarts.  gen must be safe for concurrent use.
+func withJobIDGenerator(gen func() int) controllerOption {
+	return func(c *controller) {
+		c.genID = gen
+	}
+}
+
+// withMaxJobs bounds the workload to n jobs, once n jobs have been processed the pool stops itself and closes the
+// finished channel.  Jobs left in the queue stay queued.  An n of zero or less means no limit.
+func withMaxJobs(n int) controllerOption {
+	return func(c *controller) {
+		if n <= 0 {
+			c.maxJobs, c.budget, c.budgetFreed, c.finished = 0, 0, nil, nil
+			return
+		}
+
+		c.maxJobs = int64(n)
+		c.budget = int64(n)
+		c.budgetFreed = make(chan struct{}, 1)
+		c.finished = make(chan struct{})
+	}
+}
+
+// withClientFactory gives every worker its own client built by newClient instead of sharing the controller's, for
+// upstreams that behave better with a connection pool per worker.  The idle connections of a worker's client are
+// closed when the worker stops.
+func withClientFactory(newClient func() *patterns.ClientWrapper) controllerOption {
+	return func(c *controller) {
+		c.newClient = newClient
+	}
+}
+
+// withMaxWorkers limits the size the worker pool can be grown to
+func withMaxWorkers(n int) controllerOption {
+	return func(c *controller) {
+		c.maxWorkers = n
+	}
+}
+
+// withAutoscaling enables the autoscaler which grows the worker pool while the queue depth stays above threshold and
+// shrinks it while the queue stays empty, the queue depth is polled every interval and the pool is kept between min and
+// max workers.  The pool never shrinks below one worker whatever the min, autoscaling is disabled when min exceeds max.
+func withAutoscaling(min, max, threshold int, interval time.Duration) controllerOption {
+	return func(c *controller) {
+		c.scale.min = int32(min)
+		c.scale.max = int32(max)
+		c.scale.threshold = threshold
+		c.scale.interval = interval
+	}
+}
+
+// withContext sets the root context of the controller, cancelling ctx stops the workers, the control server, and the
+// producer.  The default is context.Background.
+func withContext(ctx context.Context) controllerOption {
+	return func(c *controller) {
+		c.ctx = ctx
+	}
+}
+
+// outcomeBuffer is the number of results and of dead lettered jobs buffered for their consumers, independent of the
+// queue size so even a controller without a queue buffer doesn't hold up its workers on every outcome
+const outcomeBuffer = 100
+
+// newController initializes a controller with a work queue buffering up to queueSize jobs and a worker pool of workers
+// workers.  A deep queue smooths out bursts of work while a shallow queue applies backpressure to producers sooner.
+func newController(queueSize, workers int, cl *patterns.ClientWrapper, opts ...controllerOption) *controller {
+	c := &controller{
+		queue:   make(chan Job, queueSize),
+		results: make(chan Result, outcomeBuffer),
+		dead:    make(chan Job, outcomeBuffer),
+		ctx:     context.Background(),
+		logger:  slog.Default(),
+		remove:  make(chan struct{}),
+		cl:      cl,
+		limit:   &sync.WaitGroup{},
+		mu:      &sync.Mutex{},
+		statsMu: &sync.RWMutex{},
+		size:    workers,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+
+		pausing:  make(chan struct{}),
+		unpaused: closedChan(), // the pool starts out unpaused
+
+		sendMu:    &sync.RWMutex{},
+		closing:   make(chan struct{}),
+		closeOnce: &sync.Once{},
+
+		broker: newEventBroker(),
+	}
+	c.registerMetrics()
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.checkScaling()
+
+	c.pool, c.stopPool = context.WithCancel(c.ctx)
+
+	return c
 }
 
 func main() {
+	// cancel the context on SIGINT or SIGTERM to gracefully shut down
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
 	// create http.client
 	tr := patterns.NewTransportWrapper()
 	cl := patterns.NewClientWrapper(patterns.Transport(tr))
 
-	// create channels
-	work := make(chan int, 10)
-	done := make(chan struct{})
+	// initialize controller
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctrl := newController(10, 5, cl, withContext(ctx), withMaxWorkers(50), withLogger(logger), withTarget("http://localhost:3000"),
+		withJobStore(newFileStore("limiter-jobs.jsonl")))
 
-	var limit sync.WaitGroup
+	// consumer, receives the outcome of every job processed by the worker pool
+	go func() {
+		for res := range ctrl.results {
+			logger.Debug("result received", "job_id", res.JobID, "status", res.Status, "error", res.Err)
+		}
+	}()
 
-	// initialize controller
-	ctrl := controller{
-		queue: work,
-		done:  done,
-		cl:    cl,
-		limit: &limit,
-	}
+	// dead letter consumer, a real implementation would persist failed jobs for reprocessing
+	go func() {
+		for job := range ctrl.dead {
+			logger.Warn("job dead lettered", "job_id", job.ID, "url", job.URL)
+		}
+	}()
 
 	// http server
-	go ctrl.run()
+	serverDone := make(chan struct{})
+
+	go func() {
+		defer close(serverDone)
+		if err := ctrl.run(); err != nil {
+			logger.Error("control server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
 
 	// producer, this sends a finite number of jobs to the channel
 	// the real implementation would send incoming requests to the channel
@@ -62,93 +396,1170 @@ func main() {
 
 	go func() {
 		defer wg.Done()
+
+		// requeue the jobs left over from the last run before producing new ones
+		if _, err := ctrl.restore(); err != nil {
+			logger.Error("failed to restore jobs from the job store", "error", err)
+		}
+
 		for i := 0; i < 10000; i++ {
-			// send job to channel / queue
-			work <- i
+			// send job to channel / queue, stop producing once shutdown or a drain begins
+			if err := ctrl.submit(ctrl.ctx, Job{ID: ctrl.newJobID(), Path: "/health"}); err != nil {
+				logger.Info("producer stopped", "error", err)
+				return
+			}
 		}
 	}()
 
-	ctrl.wgroup() // starts the worker group with the default number of workers
+	ctrl.wgroup() // starts the worker group with the initial number of workers
+
+	go ctrl.autoscale()
 
 	wg.Wait()
 
-	close(work) // all work is done
+	ctrl.closeQueue() // all work is done
+
+	<-serverDone // keep serving the control endpoints until shutdown completes
 }
 
-func (c *controller) run() {
+// run serves the control endpoints until the root context is cancelled, then waits for the workers to stop, cancelling
+// the root context cancels the jobs in flight, and flushes the jobs left in the queue to the job store before gracefully
+// shutting down the server.
+func (c *controller) run() error {
 	r := mux.NewRouter().StrictSlash(true)
+	r.Handle("/healthz", c.healthz())
 	r.Handle("/stop", c.stop())
 	r.Handle("/start", c.start())
+	r.Handle("/drain", c.drain())
+	r.Handle("/pause", c.pause())
+	r.Handle("/resume", c.resume())
 	r.Handle("/worker/add", c.addWorker())
+	r.Handle("/worker/remove", c.removeWorker())
+	r.Handle("/worker/count", c.workerCount())
+	r.Handle("/deadletter/count", c.deadLetterCount())
+	r.Handle("/rate", c.rate())
+	r.Handle("/queue/depth", c.queueDepth())
+	r.Handle("/ingest", c.ingest()).Methods(http.MethodPost)
+	r.Handle("/metrics", c.prometheusMetrics())
+	r.Handle("/metrics/json", c.metrics())
+	r.Handle("/metrics/reset", c.metricsReset())
+	r.Handle("/events", c.events())
+
+	ln := c.ln
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", controlAddr); err != nil {
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Handler: r,
+	}
 
-	log.Fatal(http.ListenAndServe(":4000", r))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-c.ctx.Done():
+	}
 
+	// the worker pool context derives from the root context so the workers are already stopping
+	c.limit.Wait()
+	c.flush()
+
+	// the root context is already cancelled, give in flight control requests a bounded amount of time to complete
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
 }
 
-// wgroup starts the worker pool with the default number of workers
+// wgroup starts the worker pool with the number of workers the controller was created with.  With a stagger the workers
+// are started one at a time and wgroup returns once they all are, or the pool is stopped.
 func (c *controller) wgroup() {
-	for i := 0; i < 5; i++ {
-		c.limit.Add(1)
-		go c.startWorker()
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+
+	done := c.poolContext().Done()
+	for i := 0; i < c.size; i++ {
+		if i > 0 && !c.wait(done) {
+			return
+		}
+		c.spawnWorker()
+	}
+}
+
+// wait sleeps for a random delay of up to the stagger, it returns false if done is closed first
+func (c *controller) wait(done <-chan struct{}) bool {
+	if c.stagger <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(rand.N(c.stagger))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// startWorker runs a single worker of the worker pool, use spawnWorker to add a worker.  A worker that panics while
+// processing a job is replaced so the size of the pool is preserved, the job that caused the panic is logged.
+func (c *controller) startWorker(id int64) {
+	logger := c.logger.With("worker_id", id)
+	logger.Debug("worker started")
+
+	// the pool context is replaced on every restart, bind the worker to the one in use when it was started
+	pool := c.poolContext()
+	done := pool.Done()
+
+	cl, release := c.workerClient()
+	defer release()
+
+	var (
+		current Job  // job being processed
+		busy    bool // true while current is being processed
+		token   bool // true while holding a reservation from the job budget
+		retired bool // true when exiting after sitting idle
+	)
+
+	defer func() {
+		c.addWorkers(-1)
+		if retired {
+			c.retired()
+		}
+
+		// hand an unused reservation back for the rest of the pool
+		if token {
+			c.releaseJob()
+		}
+
+		if r := recover(); r != nil {
+			if busy {
+				logger.Error("worker panic", "job_id", current.ID, "panic", r)
+				c.deadLetter(logger, current)
+				c.jobDone()
+			} else {
+				logger.Error("worker panic", "panic", r)
+			}
+
+			// add the replacement before marking this worker done so the wait group never drops to zero in between
+			c.spawnWorker()
+		}
+
+		logger.Debug("worker stopped")
+		c.limit.Done()
+	}()
+
+	for {
+		// select picks at random between ready cases, check done first so a stopped worker doesn't keep consuming
+		// from a busy queue
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		pausing, ok := c.awaitResume(logger, done)
+		if !ok {
+			return
+		}
+
+		// under a job limit only take a job while holding a reservation, with the budget used up wait for another
+		// worker to hand one back instead, the nil channel disables the other case
+		queue, freed := c.queue, (<-chan struct{})(nil)
+		if c.maxJobs > 0 && !token {
+			if token = c.reserveJob(); !token {
+				queue, freed = nil, c.budgetFreed
+			}
+		}
+
+		idle, stopIdle := c.idleTimer()
+
+		select {
+		case <-done:
+			return
+		case <-pausing:
+			stopIdle()
+			continue
+		case <-freed:
+			stopIdle()
+			continue
+		case <-idle:
+			if retired = c.retireIdle(); retired {
+				logger.Info("idle worker exited")
+				return
+			}
+		case <-c.remove:
+			c.removed(logger)
+			return
+		case ww, ok := <-queue:
+			stopIdle()
+			if !ok {
+				return
+			}
+
+			c.checkDepth()
+
+			current, busy, token = ww, true, false
+			requeued := c.process(pool, logger, cl, ww)
+			busy = false
+			if requeued {
+				// the job goes back to the queue unprocessed, hand its reservation back with the worker's
+				token = c.maxJobs > 0
+				continue
+			}
+			c.jobDone()
+		}
+	}
+}
+
+// removed acknowledges the signal picked up by a worker picked to shrink the pool, the rest of the pool keeps running
+func (c *controller) removed(logger *slog.Logger) {
+	c.mu.Lock()
+	c.removing--
+	c.mu.Unlock()
+
+	logger.Info("worker removed")
+}
+
+// workerClient returns the client a worker issues its requests with, either the shared client or one of its own.  The
+// release func closes the idle connections of a client of its own once the worker stops.
+func (c *controller) workerClient() (*patterns.ClientWrapper, func()) {
+	if c.newClient == nil {
+		return c.cl, func() {}
+	}
+
+	cl := c.newClient()
+
+	return cl, cl.CloseIdleConnections
+}
+
+// reserveJob reserves one of the jobs left under the job limit, it returns false once the budget is used up
+func (c *controller) reserveJob() bool {
+	for {
+		left := atomic.LoadInt64(&c.budget)
+		if left <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&c.budget, left, left-1) {
+			// a single signal may have been sent for several reservations handed back, pass it on to the next worker
+			if left > 1 {
+				c.signalBudget()
+			}
+			return true
+		}
+	}
+}
+
+// releaseJob hands back a reservation that wasn't used, waking a worker waiting on the used up budget
+func (c *controller) releaseJob() {
+	atomic.AddInt64(&c.budget, 1)
+	c.signalBudget()
+}
+
+// signalBudget wakes a worker waiting for a reservation without blocking, a signal already pending covers this one
+func (c *controller) signalBudget() {
+	select {
+	case c.budgetFreed <- struct{}{}:
+	default:
+	}
+}
+
+// jobDone counts a job against the job limit, stopping the pool once the limit is reached
+func (c *controller) jobDone() {
+	if c.maxJobs <= 0 || atomic.AddInt64(&c.limited, 1) != c.maxJobs {
+		return
+	}
+
+	c.mu.Lock()
+	c.running = false
+	c.stopPool()
+	c.resumeLocked()
+	c.mu.Unlock()
+
+	c.logger.Info("job limit reached, worker pool stopped", "state", "stopped", "jobs", c.maxJobs)
+
+	// called from a worker, wait for the pool to stop without holding it up
+	go func() {
+		c.limit.Wait()
+		close(c.finished)
+	}()
+}
+
+// errDraining is returned when submitting a job to a worker pool that is draining the work queue
+var errDraining = errors.New("worker pool is draining, no new jobs are accepted")
+
+// submit sends a job to the work queue, blocking until there is room in the queue or ctx is done.  New jobs are rejected
+// while the worker pool is draining and once the queue is closed.
+func (c *controller) submit(ctx context.Context, job Job) error {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	if err := c.accepting(); err != nil {
+		return err
+	}
+
+	job = c.persist(job)
+	if err := c.send(ctx, job, true); err != nil {
+		c.release(job)
+		return err
 	}
+	c.checkDepth()
+
+	return nil
 }
 
-// startWorker adds a single worker to the worker pool
-func (c *controller) startWorker() {
-	defer c.limit.Done()
+// send adds a job to the work queue, or to the ordered queue of a controller that has one, the caller must hold a read
+// lock on sendMu.  With wait it blocks until there is room in the work queue or ctx is done, without it a full work
+// queue fails with ErrQueueFull.  The ordered queue is unbounded so it is never full.
+func (c *controller) send(ctx context.Context, job Job, wait bool) error {
+	if c.ordered != nil {
+		return c.ordered.push(job)
+	}
 
-	for ww := range c.queue {
+	if !wait {
 		select {
-		case <-c.done:
-			fmt.Println("send on done")
-			c.request(ww)
+		case c.queue <- job:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case c.queue <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-c.closing:
+		return errQueueClosed
+	}
+}
+
+// requeue puts a job taken off the queue back in it without blocking, a job in the job store keeps its entry.  It
+// returns false when the queue is full or closed.
+func (c *controller) requeue(job Job) bool {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	select {
+	case <-c.closing:
+		return false
+	default:
+	}
+
+	return c.send(c.ctx, job, false) == nil
+}
+
+// accepting returns the reason new jobs are rejected, or nil if they are accepted
+func (c *controller) accepting() error {
+	select {
+	case <-c.closing:
+		return errQueueClosed
+	default:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.draining {
+		return errDraining
+	}
+
+	return nil
+}
+
+// closeQueue closes the work queue once production is done, the workers finish the queued jobs and then terminate.
+// Producers must not close the queue themselves, jobs sent while it closes are rejected with errQueueClosed instead of
+// panicking.  Closing an already closed queue does nothing.  An ordered queue is closed instead of the work queue, the
+// dispatcher closes the work queue once it has handed out the jobs left in the ordered queue.
+func (c *controller) closeQueue() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+
+		// wait out the senders already past the closing check
+		c.sendMu.Lock()
+		if c.ordered != nil {
+			c.ordered.close()
+		} else {
+			close(c.queue)
+		}
+		c.sendMu.Unlock()
+	})
+}
+
+// ErrQueueFull is returned by Enqueue when the work queue is at capacity, callers ingesting requests should map it to
+// 429 Too Many Requests
+var ErrQueueFull = errors.New("work queue is full")
+
+// Enqueue adds a job to the work queue without blocking, shedding load with ErrQueueFull when the queue is at capacity
+// rather than holding up the caller the way submit does.  New jobs are rejected while the worker pool is draining and
+// once the queue is closed.
+func (c *controller) Enqueue(job Job) error {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	if err := c.accepting(); err != nil {
+		return err
+	}
+
+	job = c.persist(job)
+	if err := c.send(context.Background(), job, false); err != nil {
+		c.release(job)
+		return err
+	}
+	c.checkDepth()
+
+	return nil
+}
+
+// EnqueueBatch adds as many of jobs to the work queue as fit without blocking, in order, and returns how many were
+// accepted.  ErrQueueFull is returned along with the count when the queue fills before the whole batch is queued.
+func (c *controller) EnqueueBatch(jobs []Job) (accepted int, err error) {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	if err := c.accepting(); err != nil {
+		return 0, err
+	}
+
+	defer c.checkDepth()
+
+	for i, job := range jobs {
+		job = c.persist(job)
+		if err := c.send(context.Background(), job, false); err != nil {
+			c.release(job)
+			return i, err
+		}
+	}
+
+	return len(jobs), nil
+}
+
+// newJobID hands out the next job id from the id generator, ids are unique across every producer feeding the controller.
+// The default sequential ids start over when the process restarts.
+func (c *controller) newJobID() int {
+	if c.genID != nil {
+		return c.genID()
+	}
+
+	return int(atomic.AddInt64(&c.jobID, 1))
+}
+
+// ingest accepts a job spec as JSON in the request body, either a url, e.g. {"url": "http://localhost:3000/health"}, or
+// a path below the controller's target, e.g. {"path": "/health"}, and adds it to the work queue.  Jobs without an id
+// are given one by the controller's id generator.  It responds with 202 Accepted and the id of the queued job, 429 Too
+// Many Requests when the queue is full, and 503 Service Unavailable while the pool is draining.  The job is processed
+// in the background, its outcome is reported on the results channel.
+func (c *controller) ingest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var spec struct {
+			ID   int    `json:"id"`
+			URL  string `json:"url"`
+			Path string `json:"path"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&spec); err != nil {
+			http.Error(w, "invalid job spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		job := Job{ID: spec.ID, URL: spec.URL, Path: spec.Path}
+		if job.ID == 0 {
+			job.ID = c.newJobID()
+		}
+		if _, err := c.jobURL(job); err != nil {
+			http.Error(w, "invalid job spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch err := c.Enqueue(job); err {
+		case nil:
+		case ErrQueueFull:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
 			return
 		default:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(struct {
+			ID int `json:"id"`
+		}{
+			ID: job.ID,
+		})
+	}
+}
 
-			c.request(ww)
+// checkDepth logs a warning when the queue depth crosses above the high water mark, the warning is logged once per
+// crossing and is rearmed when the depth falls back to the high water mark.
+func (c *controller) checkDepth() {
+	if c.highMark <= 0 {
+		return
+	}
 
+	if depth := c.depth(); depth > c.highMark {
+		if atomic.CompareAndSwapInt32(&c.high, 0, 1) {
+			c.logger.Warn("queue depth is above the high water mark",
+				"depth", depth, "capacity", c.queueCap(), "high_water_mark", c.highMark)
 		}
+		return
+	}
+	atomic.CompareAndSwapInt32(&c.high, 1, 0)
+}
+
+// depth returns the number of jobs waiting in the work queue, or in the ordered queue of a controller that has one
+func (c *controller) depth() int {
+	if c.ordered != nil {
+		return c.ordered.len()
+	}
+
+	return len(c.queue)
+}
 
+// queueCap returns the number of jobs the work queue holds, zero for the unbounded ordered queue
+func (c *controller) queueCap() int {
+	if c.ordered != nil {
+		return 0
 	}
+
+	return cap(c.queue)
+}
+
+// poolContext returns the context of the current worker pool
+func (c *controller) poolContext() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pool
 }
 
-// stop sends a signal to all workers in the pool to complete tasks in flight and terminate, stopping consumption from the work queue.
+// stop signals all workers in the pool to complete tasks in flight and terminate, stopping consumption from the work queue.
+// Calling stop on an already stopped pool responds with a conflict.
 func (c *controller) stop() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c.done <- struct{}{}
-		close(c.done)
+		c.mu.Lock()
+		if !c.running {
+			c.mu.Unlock()
+			http.Error(w, "worker pool is already stopped", http.StatusConflict)
+			return
+		}
+		c.running = false
+		c.stopPool() // cancelling the pool context signals every worker, not just one
+		c.resumeLocked()
+		c.mu.Unlock()
 
-		fmt.Println("sent signal to done chan")
+		c.logger.Info("worker pool stopped", "state", "stopped")
 	}
 }
 
-// start restarts consumption from the work queue by reinitializing the done channel and restarting the worker pool.
+// start restarts consumption from the work queue by creating a new worker pool context and restarting the worker pool.
 func (c *controller) start() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c.done = make(chan struct{})
+		c.mu.Lock()
+		if c.running || c.draining {
+			c.mu.Unlock()
+			http.Error(w, "worker pool is already running or draining", http.StatusConflict)
+			return
+		}
+		c.pool, c.stopPool = context.WithCancel(c.ctx)
+		c.running = true
+		c.mu.Unlock()
 
 		go c.wgroup()
-		fmt.Println("restarted consumer")
+		c.logger.Info("worker pool started", "state", "running")
 
 	}
 }
 
-// addWorker will add a single worker to the worker pool
+// drain stops the work queue from accepting new jobs, the workers keep running until the queue is empty and then terminate.
+// This is the graceful counterpart to stop.
+func (c *controller) drain() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := c.beginDrain(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		c.logger.Info("draining work queue", "state", "draining")
+	}
+}
+
+// beginDrain starts draining the work queue, the returned channel is closed once the queue is empty and all workers have
+// terminated.
+func (c *controller) beginDrain() (<-chan struct{}, error) {
+	c.mu.Lock()
+	if !c.running || c.draining {
+		c.mu.Unlock()
+		return nil, errors.New("worker pool is not running or is already draining")
+	}
+	c.draining = true
+	pool := c.pool
+	// a paused pool would never empty the queue
+	c.resumeLocked()
+	c.mu.Unlock()
+
+	drained := make(chan struct{})
+
+	go func() {
+		defer close(drained)
+
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		// give up waiting if the pool is stopped or shut down before the queue empties
+		for c.depth() > 0 && pool.Err() == nil {
+			select {
+			case <-ticker.C:
+			case <-pool.Done():
+			}
+		}
+
+		// the queue is empty, stop the workers once they complete the jobs in flight
+		c.mu.Lock()
+		c.running = false
+		c.stopPool()
+		c.mu.Unlock()
+
+		c.limit.Wait()
+
+		c.mu.Lock()
+		c.draining = false
+		c.mu.Unlock()
+
+		c.logger.Info("work queue drained", "state", "stopped")
+	}()
+
+	return drained, nil
+}
+
+// addWorker adds workers to the worker pool, the number of workers to add is read from the count query parameter and
+// defaults to one.  Requests that would grow the pool past the max pool size are rejected.  The number of workers added
+// and the new total are returned in the response body.
 func (c *controller) addWorker() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c.limit.Add(1)
-		go c.startWorker()
+		count := 1
+		if v := r.URL.Query().Get("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "count must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			count = n
+		}
+
+		// hold the lock so concurrent requests can't grow the pool past the max between the check and the spawn
+		c.mu.Lock()
+		workers := atomic.LoadInt32(&c.workers)
+		if c.maxWorkers > 0 && int(workers)+count > c.maxWorkers {
+			c.mu.Unlock()
+			msg := fmt.Sprintf("adding %d workers to the %d running would exceed the max pool size of %d", count, workers, c.maxWorkers)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		for i := 0; i < count; i++ {
+			c.spawnWorker()
+		}
+		c.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Added   int   `json:"added"`
+			Workers int32 `json:"workers"`
+		}{
+			Added:   count,
+			Workers: atomic.LoadInt32(&c.workers),
+		})
+	}
+}
+
+// removeWorker signals a single worker in the worker pool to terminate after completing the task in flight.  The last
+// worker cannot be removed, use stop to halt consumption from the work queue.
+func (c *controller) removeWorker() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := c.signalRemove(r.Context(), 1)
+		if err == errMinWorkers {
+			http.Error(w, "worker pool cannot drop below one worker", http.StatusConflict)
+			return
+		}
+		if err == nil {
+			c.logger.Info("sent signal to remove worker")
+		}
+	}
+}
+
+// errMinWorkers is returned when removing a worker would shrink the pool below its minimum size
+var errMinWorkers = errors.New("worker pool is at its minimum size")
+
+// spawnWorker adds a single worker to the worker pool.  The worker is counted before it starts so the count never lags
+// behind a spawn.
+func (c *controller) spawnWorker() {
+	c.addWorkers(1)
+	c.limit.Add(1)
+	go c.startWorker(atomic.AddInt64(&c.nextID, 1))
+}
+
+// addWorkers adjusts the live worker count
+func (c *controller) addWorkers(delta int32) {
+	c.statsMu.RLock()
+	atomic.AddInt32(&c.workers, delta)
+	c.statsMu.RUnlock()
+}
+
+// signalRemove signals a single worker to terminate, blocking until a worker picks up the signal or ctx is done.  The
+// pool is never shrunk below min workers.
+func (c *controller) signalRemove(ctx context.Context, min int32) error {
+	c.mu.Lock()
+	if atomic.LoadInt32(&c.workers)-c.removing <= min {
+		c.mu.Unlock()
+		return errMinWorkers
+	}
+	c.removing++
+	c.mu.Unlock()
+
+	select {
+	case c.remove <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.removing--
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// state reports whether the worker pool is running, paused, draining, or stopped
+func (c *controller) state() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.draining:
+		return "draining"
+	case c.paused:
+		return "paused"
+	case c.running:
+		return "running"
+	default:
+		return "stopped"
+	}
+}
+
+// healthz reports the state of the controller so orchestrators can probe the control plane
+func (c *controller) healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Status     string `json:"status"`
+			Workers    int32  `json:"workers"`
+			QueueDepth int    `json:"queueDepth"`
+		}{
+			Status:     c.state(),
+			Workers:    atomic.LoadInt32(&c.workers),
+			QueueDepth: c.depth(),
+		})
+	}
+}
+
+// workerCount reports the number of live workers in the worker pool
+func (c *controller) workerCount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Workers int32 `json:"workers"`
+		}{
+			Workers: atomic.LoadInt32(&c.workers),
+		})
 	}
 }
 
-// request is the work function
-func (c *controller) request(i int) {
-	resp, err := c.cl.Cl.Get("http://localhost:3000/health")
+// process runs a single job for a worker of the pool, a failed job is logged and never stops the worker from processing
+// the rest of the queue.  It reports whether the job was put back in the queue by a stop before it started, such a job
+// isn't counted as processed.
+func (c *controller) process(pool context.Context, logger *slog.Logger, cl *patterns.ClientWrapper,
+	job Job) (requeued bool) {
+	c.statsMu.RLock()
+	atomic.AddInt64(&c.inFlight, 1)
+	c.statsMu.RUnlock()
+
+	completed := false // false when the job panics
+	failed := false
+	interrupted := false // true when the job failed because the controller shut down
+	defer func() {
+		if requeued {
+			atomic.AddInt64(&c.inFlight, -1)
+			return
+		}
+
+		// a processed job, even one that panicked, is never restored from the job store, a job cut short by the
+		// shutdown is still pending and flushed to the store with the queued jobs
+		if !interrupted {
+			c.release(job)
+		}
+
+		// deferred so the counters stay accurate when the job panics, the counters are updated together so a Stats
+		// snapshot never sees a job counted as processed but not yet as failed
+		c.statsMu.RLock()
+		atomic.AddInt64(&c.inFlight, -1)
+		atomic.AddInt64(&c.processed, 1)
+		if failed || !completed {
+			atomic.AddInt64(&c.failed, 1)
+		}
+		c.statsMu.RUnlock()
+	}()
+
+	ctx, stop := c.jobContext(job)
+	defer stop()
+
+	// a slow upstream must not tie up the worker indefinitely
+	if c.jobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.jobTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	status, err := c.execute(ctx, pool, cl, job)
+	if errors.Is(err, errRequeued) {
+		logger.Debug("job requeued", "job_id", job.ID)
+		return true
+	}
+	c.checkConn(logger, cl, status, err != nil && ctx.Err() == nil)
+	c.result(logger, Result{JobID: job.ID, Status: status, Err: err})
+	c.publishEvent(job, status, time.Since(start), err)
+	if err != nil {
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		interrupted = ctx.Err() != nil && c.ctx.Err() != nil
+		logger.Warn("job failed", "job_id", job.ID, "status", status, "timed_out", timedOut, "error", err)
+		completed, failed = true, true
+		if !timedOut || c.deadLetterTimeouts {
+			c.deadLetter(logger, job)
+		}
+		return
+	}
+
+	logger.Info("job completed", "job_id", job.ID, "status", status)
+	completed = true
+
+	return false
+}
+
+// jobContext returns the context a job runs with, the job's own context or the root context for a job without one.  The
+// job's own context is cancelled along with the root context so shutting down cancels every job in flight.
+func (c *controller) jobContext(job Job) (context.Context, context.CancelFunc) {
+	if job.Ctx == nil {
+		return context.WithCancel(c.ctx)
+	}
+
+	ctx, cancel := context.WithCancel(job.Ctx)
+	stop := context.AfterFunc(c.ctx, cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// checkConn tracks consecutive connection errors, jobs that failed without a response for a reason other than their
+// context ending, and closes the client's idle connections once there have been resetAfter in a row so the next jobs
+// dial fresh connections.  Any response resets the count.  With a client per worker only the idle connections of the
+// worker that hit the threshold are closed.
+func (c *controller) checkConn(logger *slog.Logger, cl *patterns.ClientWrapper, status int, failed bool) {
+	if c.resetAfter <= 0 {
+		return
+	}
+
+	if !failed || status != 0 {
+		atomic.StoreInt64(&c.connErrs, 0)
+		return
+	}
+
+	// only the worker that hits the threshold resets, the count starts over for the next round of errors
+	if atomic.AddInt64(&c.connErrs, 1) == c.resetAfter {
+		atomic.StoreInt64(&c.connErrs, 0)
+		cl.CloseIdleConnections()
+		logger.Warn("closed idle connections after consecutive connection errors", "errors", c.resetAfter)
+	}
+}
+
+// result reports the outcome of a job on the results channel.  Workers never block on a full results channel, consumers
+// that need every outcome must keep up, a result that doesn't fit is logged and dropped instead.
+func (c *controller) result(logger *slog.Logger, res Result) {
+	select {
+	case c.results <- res:
+	default:
+		logger.Error("results channel is full, dropping result", "job_id", res.JobID)
+	}
+}
+
+// deadLetter sends a failed job to the dead letter queue.  Workers never block on a full dead letter queue, the job is
+// logged and dropped instead.
+func (c *controller) deadLetter(logger *slog.Logger, job Job) {
+	select {
+	case c.dead <- job:
+		atomic.AddInt64(&c.deadN, 1)
+	default:
+		logger.Error("dead letter queue is full, dropping job", "job_id", job.ID)
+	}
+}
+
+// deadLetterCount reports the number of jobs sent to the dead letter queue
+func (c *controller) deadLetterCount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			DeadLetter int64 `json:"deadLetter"`
+		}{
+			DeadLetter: atomic.LoadInt64(&c.deadN),
+		})
+	}
+}
+
+// queueDepth reports the number of jobs waiting in the work queue and the capacity of the queue, zero when unbounded
+func (c *controller) queueDepth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Depth         int `json:"depth"`
+			Capacity      int `json:"capacity"`
+			HighWaterMark int `json:"highWaterMark"`
+		}{
+			Depth:         c.depth(),
+			Capacity:      c.queueCap(),
+			HighWaterMark: c.highMark,
+		})
+	}
+}
+
+// Stats is a point in time snapshot of the controller
+type Stats struct {
+	WorkerCount int32 `json:"workers"`
+	QueueDepth  int   `json:"queueDepth"`
+	Processed   int64 `json:"processed"`
+	Failed      int64 `json:"failed"`
+	InFlight    int64 `json:"inFlight"`
+}
+
+// Stats returns a snapshot of the controller for programmatic use, Processed and Failed count the jobs since the last
+// ResetStats.  The counters are read together so the snapshot is internally consistent, for example Failed never
+// includes a job that Processed doesn't.
+func (c *controller) Stats() Stats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	return c.statsLocked()
+}
+
+// ResetStats returns a snapshot of the controller and resets the processed and failed counts to zero, reading the
+// snapshot before each reset gives the counts for the interval since the last one.  No job is lost or counted twice
+// between the snapshot and the reset.  Only the counts reported by Stats are reset, the prometheus totals keep counting.
+func (c *controller) ResetStats() Stats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	s := c.statsLocked()
+	c.resetProcessed += s.Processed
+	c.resetFailed += s.Failed
+
+	return s
+}
+
+// statsLocked returns a snapshot of the controller, statsMu must be held for writing
+func (c *controller) statsLocked() Stats {
+	return Stats{
+		WorkerCount: atomic.LoadInt32(&c.workers),
+		QueueDepth:  c.depth(),
+		Processed:   atomic.LoadInt64(&c.processed) - c.resetProcessed,
+		Failed:      atomic.LoadInt64(&c.failed) - c.resetFailed,
+		InFlight:    atomic.LoadInt64(&c.inFlight),
+	}
+}
+
+// metrics reports a snapshot of the worker count, queue depth, and the number of jobs processed, failed, and currently
+// in flight
+func (c *controller) metrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Stats())
+	}
+}
+
+// metricsReset reports the same snapshot as metrics and resets the processed and failed counters, for scrapers that
+// compute rates per interval.  The prometheus counters aren't affected by the reset.
+func (c *controller) metricsReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.ResetStats())
+	}
+}
+
+// rate reports the request rate limit of the worker pool.  The limit can be adjusted at runtime with the limit query
+// parameter in requests per second, use "inf" to remove the limit, and the burst query parameter.  A limit of zero is
+// rejected since it would hold up the workers forever, use pause to halt consumption instead.
+func (c *controller) rate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("limit"); v != "" {
+			limit, err := strconv.ParseFloat(v, 64)
+			if err != nil || !(limit > 0) { // also rejects NaN
+				http.Error(w, "limit must be a positive number of requests per second", http.StatusBadRequest)
+				return
+			}
+			// "inf" parses to +Inf, the limiter's own infinity is the largest float
+			if math.IsInf(limit, 1) {
+				c.limiter.SetLimit(rate.Inf)
+			} else {
+				c.limiter.SetLimit(rate.Limit(limit))
+			}
+		}
+
+		if v := r.URL.Query().Get("burst"); v != "" {
+			burst, err := strconv.Atoi(v)
+			if err != nil || burst < 1 {
+				http.Error(w, "burst must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			c.limiter.SetBurst(burst)
+		}
+
+		resp := struct {
+			Limit *float64 `json:"limit"` // requests per second, null when unlimited
+			Burst int      `json:"burst"`
+		}{
+			Burst: c.limiter.Burst(),
+		}
+		if limit := c.limiter.Limit(); limit != rate.Inf {
+			l := float64(limit)
+			resp.Limit = &l
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// execute waits for enough concurrency slots for the job's weight and for the rate limiter, and then runs the job with
+// the controller's request func, or issues the default GET request when no request func is set.  Jobs without a URL get
+// their path resolved against the target first.  The response status is only known for the default request.  A stop
+// interrupting the waits puts the job back in the queue and returns errRequeued, see await.
+func (c *controller) execute(ctx, pool context.Context, cl *patterns.ClientWrapper, job Job) (int, error) {
+	if c.slots != nil {
+		weight := int64(job.Weight)
+		if weight < 1 {
+			weight = 1
+		}
+		// a job heavier than the capacity would wait forever
+		if weight > c.capacity {
+			return 0, fmt.Errorf("job weight %d exceeds the capacity of %d", weight, c.capacity)
+		}
+		acquire := func(ctx context.Context) error { return c.slots.Acquire(ctx, weight) }
+		if err := c.await(ctx, pool, job, acquire); err != nil {
+			return 0, err
+		}
+		defer c.slots.Release(weight)
+	}
+
+	u, err := c.resolve(job)
+	if err != nil {
+		return 0, err
+	}
+	job.URL = u
+
+	if err := c.await(ctx, pool, job, c.limiter.Wait); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	defer func() {
+		c.latency.Observe(time.Since(start).Seconds())
+	}()
+
+	if c.work != nil {
+		return 0, c.work(ctx, job)
+	}
+
+	return c.request(ctx, cl, job)
+}
+
+// errRequeued is returned by execute for a job put back in the queue by a stop before it started
+var errRequeued = errors.New("job requeued")
+
+// await runs wait, a wait for concurrency slots or the rate limiter, so a stop can interrupt it and let the worker exit
+// without waiting out the limit.  The interrupted job is put back in the queue rather than failed and errRequeued is
+// returned, when the queue has no room for it the job keeps waiting instead.  A draining pool lets the job wait, the
+// drain processes every queued job, only the job's own context cuts the wait short then.
+func (c *controller) await(ctx, pool context.Context, job Job, wait func(ctx context.Context) error) error {
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(pool, func() {
+		c.mu.Lock()
+		draining := c.draining
+		c.mu.Unlock()
+
+		if !draining {
+			cancel()
+		}
+	})()
+
+	err := wait(waitCtx)
+	if err == nil || waitCtx.Err() == nil || ctx.Err() != nil {
+		return err
+	}
+
+	if c.requeue(job) {
+		return errRequeued
+	}
+
+	return wait(ctx)
+}
+
+// resolve returns the URL a job requests, the job's own URL or else its path resolved against the controller's target.
+// Without either the URL is empty, a request func may not need one.
+func (c *controller) resolve(job Job) (string, error) {
+	if job.URL != "" || c.target == "" {
+		return job.URL, nil
+	}
+
+	return url.JoinPath(c.target, job.Path)
+}
+
+// jobURL returns the URL a job requests, failing unless it is an absolute http or https URL
+func (c *controller) jobURL(job Job) (string, error) {
+	raw, err := c.resolve(job)
+	if err != nil {
+		return "", err
+	}
+
+	if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute http or https url", raw)
+	}
+
+	return raw, nil
+}
+
+// request is the default work function, it issues a GET to the job's URL and returns the response status
+func (c *controller) request(ctx context.Context, cl *patterns.ClientWrapper, job Job) (int, error) {
+	resp, err := cl.Get(ctx, job.URL)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return 0, err
 	}
 	defer resp.Body.Close()
-	b, _ := ioutil.ReadAll(resp.Body)
-	fmt.Printf("request: %d %v", i, string(b))
+
+	// read the body to completion so the connection can be reused
+	_, err = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, err
 }
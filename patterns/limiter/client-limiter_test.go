@@ -0,0 +1,1771 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"examples/patterns"
+)
+
+// newTestController creates a controller pointed at srv with a discarded log, the controller's root context is
+// cancelled when the test ends
+func newTestController(t *testing.T, srv *httptest.Server, queueSize, workers int,
+	opts ...controllerOption) *controller {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	opts = append([]controllerOption{
+		withContext(ctx),
+		withLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		withTarget(srv.URL),
+	}, opts...)
+
+	return newController(queueSize, workers, patterns.NewClientWrapper(), opts...)
+}
+
+// newOKServer starts a server responding 200 OK to every request, it is closed when the test ends
+func newOKServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// waitFor polls cond until it holds, failing the test if it doesn't within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// call serves a request to a control endpoint handler and returns the recorded response
+func call(h http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, body))
+
+	return rec
+}
+
+// decode decodes the JSON body of a recorded response into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+}
+
+func TestWithCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		weight   int
+		wantErr  bool
+	}{
+		{name: "zero is unlimited", capacity: 0, weight: 5},
+		{name: "negative is unlimited", capacity: -1, weight: 5},
+		{name: "within capacity", capacity: 2, weight: 2},
+		{name: "heavier than capacity", capacity: 2, weight: 3, wantErr: true},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, 1, withCapacity(tt.capacity))
+
+			status, err := c.execute(context.Background(), context.Background(), c.cl, Job{ID: 1, Path: "/", Weight: tt.weight})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("execute() succeeded with status %d, want an error", status)
+				}
+				return
+			}
+			if err != nil || status != http.StatusOK {
+				t.Fatalf("execute() = %d, %v, want %d, nil", status, err, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestWithMaxJobs(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxJobs       int
+		wantProcessed int64
+		wantQueued    int
+	}{
+		{name: "zero is no limit", maxJobs: 0, wantProcessed: 5},
+		{name: "negative is no limit", maxJobs: -1, wantProcessed: 5},
+		{name: "limit below the workload", maxJobs: 3, wantProcessed: 3, wantQueued: 2},
+		{name: "limit above the workload", maxJobs: 10, wantProcessed: 5},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 10, 2, withMaxJobs(tt.maxJobs))
+			for i := 1; i <= 5; i++ {
+				if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("submit() error = %v", err)
+				}
+			}
+
+			c.wgroup()
+
+			if c.finished != nil && tt.wantQueued > 0 {
+				select {
+				case <-c.finished:
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for the job limit")
+				}
+			}
+			waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == tt.wantProcessed })
+
+			if got := c.Stats(); got.Processed != tt.wantProcessed || got.QueueDepth != tt.wantQueued {
+				t.Errorf("processed %d with %d queued, want %d with %d queued",
+					got.Processed, got.QueueDepth, tt.wantProcessed, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestRateHandler(t *testing.T) {
+	limit := func(l float64) *float64 { return &l }
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantLimit *float64
+		wantBurst int
+	}{
+		{name: "report", query: "", wantCode: http.StatusOK, wantBurst: 1},
+		{name: "set limit", query: "?limit=2.5&burst=3", wantCode: http.StatusOK, wantLimit: limit(2.5), wantBurst: 3},
+		{name: "remove limit", query: "?limit=inf", wantCode: http.StatusOK, wantBurst: 1},
+		{name: "zero limit", query: "?limit=0", wantCode: http.StatusBadRequest},
+		{name: "negative limit", query: "?limit=-1", wantCode: http.StatusBadRequest},
+		{name: "nan limit", query: "?limit=NaN", wantCode: http.StatusBadRequest},
+		{name: "zero burst", query: "?burst=0", wantCode: http.StatusBadRequest},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, 1)
+
+			rec := httptest.NewRecorder()
+			c.rate()(rec, httptest.NewRequest(http.MethodGet, "/rate"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if c.limiter.Limit() != rate.Inf {
+					t.Errorf("limit changed to %v by a rejected request", c.limiter.Limit())
+				}
+				return
+			}
+
+			var got struct {
+				Limit *float64 `json:"limit"`
+				Burst int      `json:"burst"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if (got.Limit == nil) != (tt.wantLimit == nil) || (got.Limit != nil && *got.Limit != *tt.wantLimit) {
+				t.Errorf("limit = %v, want %v", got.Limit, tt.wantLimit)
+			}
+			if got.Burst != tt.wantBurst {
+				t.Errorf("burst = %d, want %d", got.Burst, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit rate.Limit
+		want  rate.Limit
+	}{
+		{name: "limited", limit: 10, want: 10},
+		{name: "zero is ignored", limit: 0, want: rate.Inf},
+		{name: "negative is ignored", limit: -1, want: rate.Inf},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, 1, withRateLimit(tt.limit, 2))
+			if got := c.limiter.Limit(); got != tt.want {
+				t.Errorf("limit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitThroughput(t *testing.T) {
+	const (
+		jobs  = 30
+		limit = 50 // requests per second
+		burst = 5
+	)
+
+	var (
+		mu       sync.Mutex
+		arrivals []time.Time
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+	}))
+	t.Cleanup(upstream.Close)
+
+	// more workers than the burst, only the limiter holds them back
+	c := newTestController(t, upstream, jobs, 8, withRateLimit(limit, burst))
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	start := time.Now()
+	c.wgroup()
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == jobs })
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// a token bucket lets through at most the burst plus the limit for every second elapsed since the workers started
+	for i, at := range arrivals {
+		elapsed := at.Sub(start).Seconds()
+		if allowed := burst + limit*elapsed; float64(i+1) > allowed+1 { // one request of slack for clock granularity
+			t.Fatalf("%d requests after %.3fs, want at most %.1f at %d/s with a burst of %d", i+1, elapsed, allowed,
+				limit, burst)
+		}
+	}
+	if elapsed := arrivals[len(arrivals)-1].Sub(start); elapsed < (jobs-burst)*time.Second/limit*9/10 {
+		t.Errorf("%d requests took %v, the limit of %d/s wasn't enforced", jobs, elapsed, limit)
+	}
+}
+
+// TestStopInterruptsRateLimitWait checks a worker waiting on the rate limiter stops with the pool instead of waiting
+// out the limit
+func TestStopInterruptsRateLimitWait(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 1, withRateLimit(rate.Every(time.Hour), 1))
+	for i := 1; i <= 2; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+
+	c.wgroup()
+	waitFor(t, "the first job", func() bool { return c.Stats().Processed == 1 })
+
+	c.stop()(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stop", nil))
+
+	stopped := make(chan struct{})
+	go func() {
+		c.limit.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker still waiting on the rate limiter after stop")
+	}
+
+	// the interrupted job goes back to the queue for the next start instead of failing
+	if s := c.Stats(); s.Processed != 1 || s.Failed != 0 || s.InFlight != 0 || s.QueueDepth != 1 {
+		t.Errorf("stats = %+v, want the second job requeued", s)
+	}
+	select {
+	case job := <-c.queue:
+		if job.ID != 2 {
+			t.Errorf("requeued job %d, want 2", job.ID)
+		}
+	default:
+		t.Error("the interrupted job isn't back in the queue")
+	}
+	if len(c.dead) != 0 {
+		t.Errorf("%d jobs dead lettered, want none", len(c.dead))
+	}
+}
+
+// TestDrainRateLimited checks a drain processes the jobs waiting on the rate limiter instead of failing them
+func TestDrainRateLimited(t *testing.T) {
+	const jobs = 10
+
+	c := newTestController(t, newOKServer(t), jobs, 3, withRateLimit(rate.Every(20*time.Millisecond), 1))
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+
+	drained, err := c.beginDrain()
+	if err != nil {
+		t.Fatalf("beginDrain() error = %v", err)
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the drain")
+	}
+
+	if s := c.Stats(); s.Processed != jobs || s.Failed != 0 || s.QueueDepth != 0 || s.WorkerCount != 0 {
+		t.Errorf("stats = %+v, want all %d jobs processed without failures", s, jobs)
+	}
+	if len(c.dead) != 0 {
+		t.Errorf("%d jobs dead lettered, want none", len(c.dead))
+	}
+}
+
+func TestJobContext(t *testing.T) {
+	type ctxKey struct{}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{name: "without a job context"},
+		{name: "with a job context", ctx: context.WithValue(context.Background(), ctxKey{}, "job")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-r.Context().Done()
+			}))
+			t.Cleanup(srv.Close)
+
+			root, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			c := newTestController(t, srv, 10, 1, withContext(root))
+			// a strict client rejects jobs that would run with the background context
+			c.cl = patterns.NewClientWrapper(patterns.StrictContext())
+
+			if err := c.submit(context.Background(), Job{ID: 1, Path: "/", Ctx: tt.ctx}); err != nil {
+				t.Fatalf("submit() error = %v", err)
+			}
+			c.wgroup()
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the job never reached the upstream")
+			}
+
+			cancel()
+
+			stopped := make(chan struct{})
+			go func() {
+				c.limit.Wait()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the job in flight wasn't cancelled with the root context")
+			}
+
+			res := <-c.results
+			if !errors.Is(res.Err, context.Canceled) {
+				t.Errorf("result error = %v, want %v", res.Err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	// a listener closed right away leaves an address that refuses connections
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		job        Job
+		wantStatus int
+		wantErr    bool
+	}{
+		{name: "success", job: Job{ID: 1, Path: "/"}, wantStatus: http.StatusOK},
+		{name: "error status", job: Job{ID: 2, Path: "/missing"}, wantStatus: http.StatusNotFound},
+		{name: "connection refused", job: Job{ID: 3, URL: closed.URL}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, 1)
+			if err := c.submit(context.Background(), tt.job); err != nil {
+				t.Fatalf("submit() error = %v", err)
+			}
+			c.wgroup()
+
+			var res Result
+			select {
+			case res = <-c.results:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the result")
+			}
+
+			if res.JobID != tt.job.ID || res.Status != tt.wantStatus || (res.Err != nil) != tt.wantErr {
+				t.Errorf("result = %+v, want job %d with status %d and error %t", res, tt.job.ID, tt.wantStatus, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestResultsNotConsumed checks the workers keep processing when nobody consumes the results
+func TestResultsNotConsumed(t *testing.T) {
+	const jobs = outcomeBuffer + 10
+
+	c := newTestController(t, newOKServer(t), 0, 2)
+	c.wgroup()
+
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(c.ctx, Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == jobs })
+	if got := len(c.results); got != outcomeBuffer {
+		t.Errorf("%d results buffered, want %d", got, outcomeBuffer)
+	}
+}
+
+func TestRemoveWorker(t *testing.T) {
+	tests := []struct {
+		name        string
+		workers     int
+		wantCode    int
+		wantWorkers int32
+	}{
+		{name: "removes a worker", workers: 3, wantCode: http.StatusOK, wantWorkers: 2},
+		{name: "keeps the last worker", workers: 1, wantCode: http.StatusConflict, wantWorkers: 1},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, tt.workers)
+			c.wgroup()
+
+			if rec := call(c.removeWorker(), http.MethodGet, "/worker/remove", nil); rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			waitFor(t, "the worker count", func() bool { return atomic.LoadInt32(&c.workers) == tt.wantWorkers })
+		})
+	}
+}
+
+func TestStopStart(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 2)
+	c.wgroup()
+
+	steps := []struct {
+		name      string
+		handler   http.HandlerFunc
+		wantCode  int
+		wantState string
+	}{
+		{name: "stop", handler: c.stop(), wantCode: http.StatusOK, wantState: "stopped"},
+		{name: "stop again", handler: c.stop(), wantCode: http.StatusConflict, wantState: "stopped"},
+		{name: "start", handler: c.start(), wantCode: http.StatusOK, wantState: "running"},
+		{name: "start again", handler: c.start(), wantCode: http.StatusConflict, wantState: "running"},
+		{name: "stop after restart", handler: c.stop(), wantCode: http.StatusOK, wantState: "stopped"},
+	}
+
+	for _, step := range steps {
+		if rec := call(step.handler, http.MethodGet, "/", nil); rec.Code != step.wantCode {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rec.Code, step.wantCode, rec.Body)
+		}
+		if got := c.state(); got != step.wantState {
+			t.Errorf("%s: state = %q, want %q", step.name, got, step.wantState)
+		}
+	}
+
+	waitFor(t, "the workers to stop", func() bool { return atomic.LoadInt32(&c.workers) == 0 })
+}
+
+func TestWorkerCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		remove  int
+		want    int32
+	}{
+		{name: "initial pool", workers: 3, want: 3},
+		{name: "after a removal", workers: 3, remove: 1, want: 2},
+		{name: "stopped pool", workers: 0, want: 0},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, tt.workers)
+			c.wgroup()
+			for i := 0; i < tt.remove; i++ {
+				if err := c.signalRemove(context.Background(), 1); err != nil {
+					t.Fatalf("signalRemove() error = %v", err)
+				}
+			}
+
+			var got struct {
+				Workers int32 `json:"workers"`
+			}
+			waitFor(t, "the worker count", func() bool {
+				decode(t, call(c.workerCount(), http.MethodGet, "/worker/count", nil), &got)
+				return got.Workers == tt.want
+			})
+		})
+	}
+}
+
+// TestRunShutdown checks the control server shuts down gracefully once the root context is cancelled.  run listens on
+// the fixed control port, the test fails if the port is taken.
+func TestRunShutdown(t *testing.T) {
+	root, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening for the control server: %v", err)
+	}
+
+	c := newTestController(t, newOKServer(t), 10, 2, withContext(root), withListener(ln))
+	c.wgroup()
+
+	errs := make(chan error, 1)
+	go func() { errs <- c.run() }()
+
+	waitFor(t, "the control server", func() bool {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("run() error = %v, want a graceful shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() didn't return after the root context was cancelled")
+	}
+
+	if got := atomic.LoadInt32(&c.workers); got != 0 {
+		t.Errorf("%d workers still running after shutdown", got)
+	}
+}
+
+func TestQueueSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueSize int
+	}{
+		{name: "single slot", queueSize: 1},
+		{name: "deep buffer", queueSize: 5},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, tt.queueSize, 1)
+			for i := 1; i <= tt.queueSize; i++ {
+				if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("submit() error = %v", err)
+				}
+			}
+
+			// no worker is consuming, a producer blocks once the buffer is full
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := c.submit(ctx, Job{ID: tt.queueSize + 1, Path: "/"}); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("submit() to a full queue error = %v, want %v", err, context.DeadlineExceeded)
+			}
+
+			// a worker consuming frees a slot for the blocked producer
+			c.wgroup()
+			if err := c.submit(c.ctx, Job{ID: tt.queueSize + 1, Path: "/"}); err != nil {
+				t.Fatalf("submit() once a worker is consuming error = %v", err)
+			}
+		})
+	}
+}
+
+func TestJobURL(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		hits = map[string]int{}
+	)
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			hits[name+r.URL.Path]++
+		}
+	}
+	target := httptest.NewServer(record("target"))
+	t.Cleanup(target.Close)
+	other := httptest.NewServer(record("other"))
+	t.Cleanup(other.Close)
+
+	jobs := []Job{
+		{ID: 1, Path: "/health"},
+		{ID: 2, Path: "/orders"},
+		{ID: 3, URL: other.URL + "/users"},
+		{ID: 4, URL: other.URL + "/users", Path: "/ignored"},
+	}
+	want := map[string]int{"target/health": 1, "target/orders": 1, "other/users": 2}
+
+	c := newTestController(t, target, len(jobs), 2)
+	for _, job := range jobs {
+		if err := c.submit(context.Background(), job); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == int64(len(jobs)) })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !maps.Equal(hits, want) {
+		t.Errorf("endpoints hit = %v, want %v", hits, want)
+	}
+}
+
+// TestFailedJobKeepsWorker checks a job failing to connect doesn't take down the worker processing it
+func TestFailedJobKeepsWorker(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	c := newTestController(t, newOKServer(t), 5, 1)
+	jobs := []Job{{ID: 1, URL: closed.URL}, {ID: 2, Path: "/"}, {ID: 3, URL: closed.URL}, {ID: 4, Path: "/"}}
+	for _, job := range jobs {
+		if err := c.submit(context.Background(), job); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+
+	waitFor(t, "the queue to drain", func() bool { return c.Stats().Processed == int64(len(jobs)) })
+	if s := c.Stats(); s.Failed != 2 || s.WorkerCount != 1 {
+		t.Errorf("stats = %+v, want 2 failed jobs and the worker still running", s)
+	}
+}
+
+func TestWorkerPanic(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []int
+	)
+	work := func(ctx context.Context, job Job) error {
+		if job.ID == 2 {
+			panic("boom")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, job.ID)
+
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), 5, 2, withRequestFunc(work))
+	for i := 1; i <= 5; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+
+	waitFor(t, "the queue to drain", func() bool { return c.Stats().Processed == 5 })
+	mu.Lock()
+	if len(seen) != 4 {
+		t.Errorf("jobs %v completed, want every job but the one that panicked", seen)
+	}
+	mu.Unlock()
+
+	if got := c.Stats().Failed; got != 1 {
+		t.Errorf("%d failed jobs, want 1", got)
+	}
+	// the replacement is spawned once the panicking worker has unwound
+	waitFor(t, "the replacement worker", func() bool { return atomic.LoadInt32(&c.workers) == 2 })
+
+	select {
+	case job := <-c.dead:
+		if job.ID != 2 {
+			t.Errorf("job %d dead lettered, want job 2", job.ID)
+		}
+	default:
+		t.Error("the job that panicked wasn't dead lettered")
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	work := func(ctx context.Context, job Job) error {
+		if job.ID%2 == 0 {
+			return errors.New("upstream rejected the job")
+		}
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), 5, 1, withRequestFunc(work))
+	for i := 1; i <= 4; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+	waitFor(t, "the queue to drain", func() bool { return c.Stats().Processed == 4 })
+
+	var got []int
+	for len(c.dead) > 0 {
+		got = append(got, (<-c.dead).ID)
+	}
+	if !slices.Equal(got, []int{2, 4}) {
+		t.Errorf("dead lettered jobs = %v, want [2 4]", got)
+	}
+
+	var count struct {
+		DeadLetter int64 `json:"deadLetter"`
+	}
+	decode(t, call(c.deadLetterCount(), http.MethodGet, "/deadletter/count", nil), &count)
+	if count.DeadLetter != 2 {
+		t.Errorf("dead letter count = %d, want 2", count.DeadLetter)
+	}
+}
+
+// TestConcurrentStopStart restarts the pool from several goroutines while jobs flow, run it with -race
+func TestConcurrentStopStart(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 3)
+	c.wgroup()
+
+	produced := make(chan struct{})
+	go func() {
+		defer close(produced)
+		for i := 1; i <= 50; i++ {
+			if err := c.submit(c.ctx, Job{ID: i, Path: "/"}); err != nil {
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				call(c.stop(), http.MethodGet, "/stop", nil)
+				call(c.start(), http.MethodGet, "/start", nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// leave the pool running so the producer finishes
+	call(c.start(), http.MethodGet, "/start", nil)
+	select {
+	case <-produced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("producer stuck after the restarts")
+	}
+
+	call(c.stop(), http.MethodGet, "/stop", nil)
+	waitFor(t, "the workers to stop", func() bool { return atomic.LoadInt32(&c.workers) == 0 })
+}
+
+// TestStopReachesAllWorkers checks a single stop terminates every worker once it finishes the job in flight
+func TestStopReachesAllWorkers(t *testing.T) {
+	const workers = 5
+
+	release := make(chan struct{})
+	var started int64
+	work := func(ctx context.Context, job Job) error {
+		atomic.AddInt64(&started, 1)
+		<-release
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), 10, workers, withRequestFunc(work))
+	for i := 1; i <= 10; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+	waitFor(t, "every worker to pick up a job", func() bool { return atomic.LoadInt64(&started) == workers })
+
+	if rec := call(c.stop(), http.MethodGet, "/stop", nil); rec.Code != http.StatusOK {
+		t.Fatalf("stop status = %d: %s", rec.Code, rec.Body)
+	}
+	close(release)
+
+	waitFor(t, "every worker to exit", func() bool { return atomic.LoadInt32(&c.workers) == 0 })
+	if s := c.Stats(); s.Processed != workers || s.QueueDepth != 5 {
+		t.Errorf("stats = %+v, want the %d jobs in flight finished and the rest left queued", s, workers)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	const jobs = 100
+
+	release := make(chan struct{})
+	work := func(ctx context.Context, job Job) error {
+		<-release
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), jobs, 4, withRequestFunc(work))
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+
+	drained, err := c.beginDrain()
+	if err != nil {
+		t.Fatalf("beginDrain() error = %v", err)
+	}
+	if err := c.submit(context.Background(), Job{ID: jobs + 1}); !errors.Is(err, errDraining) {
+		t.Errorf("submit() while draining error = %v, want %v", err, errDraining)
+	}
+	if rec := call(c.drain(), http.MethodGet, "/drain", nil); rec.Code != http.StatusConflict {
+		t.Errorf("drain while draining status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	close(release)
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the drain")
+	}
+
+	if s := c.Stats(); s.Processed != jobs || s.QueueDepth != 0 || s.WorkerCount != 0 {
+		t.Errorf("stats = %+v, want all %d jobs processed and the workers exited", s, jobs)
+	}
+	if got := c.state(); got != "stopped" {
+		t.Errorf("state = %q, want stopped", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	const (
+		batch   = 10
+		workers = 2
+	)
+
+	release := make(chan struct{})
+	work := func(ctx context.Context, job Job) error {
+		<-release
+		if job.ID <= 3 {
+			return errors.New("failed")
+		}
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), batch, workers, withRequestFunc(work))
+	for i := 1; i <= batch; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+
+	var got Stats
+	waitFor(t, "the jobs in flight", func() bool {
+		decode(t, call(c.metrics(), http.MethodGet, "/metrics", nil), &got)
+		return got.InFlight == workers
+	})
+
+	close(release)
+	waitFor(t, "the batch to be processed", func() bool {
+		decode(t, call(c.metrics(), http.MethodGet, "/metrics", nil), &got)
+		return got.Processed == batch
+	})
+	if got.Failed != 3 || got.InFlight != 0 {
+		t.Errorf("metrics = %+v, want 3 failed and none in flight", got)
+	}
+}
+
+func TestHighWaterMark(t *testing.T) {
+	var logs bytes.Buffer
+	c := newTestController(t, newOKServer(t), 5, 1, withHighWaterMark(2),
+		withLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	warnings := func() int { return strings.Count(logs.String(), "above the high water mark") }
+
+	// no workers are running, the test alone moves the queue depth
+	steps := []struct {
+		name     string
+		submit   int // jobs submitted
+		take     int // jobs taken off the queue
+		depth    int
+		warnings int
+	}{
+		{name: "up to the mark", submit: 2, depth: 2},
+		{name: "crossing", submit: 1, depth: 3, warnings: 1},
+		{name: "staying above", submit: 1, depth: 4, warnings: 1},
+		{name: "falling back", take: 2, depth: 2, warnings: 1},
+		{name: "crossing again", submit: 1, depth: 3, warnings: 2},
+	}
+
+	for _, step := range steps {
+		for i := 0; i < step.submit; i++ {
+			if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+				t.Fatalf("%s: submit() error = %v", step.name, err)
+			}
+		}
+		for i := 0; i < step.take; i++ {
+			<-c.queue
+			c.checkDepth()
+		}
+
+		var got struct {
+			Depth         int `json:"depth"`
+			Capacity      int `json:"capacity"`
+			HighWaterMark int `json:"highWaterMark"`
+		}
+		decode(t, call(c.queueDepth(), http.MethodGet, "/queue/depth", nil), &got)
+		if got.Depth != step.depth || got.Capacity != 5 || got.HighWaterMark != 2 {
+			t.Errorf("%s: queue depth = %+v, want depth %d of 5 with a mark of 2", step.name, got, step.depth)
+		}
+		if n := warnings(); n != step.warnings {
+			t.Errorf("%s: %d warnings logged, want %d", step.name, n, step.warnings)
+		}
+	}
+}
+
+func TestAddWorker(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantCode    int
+		wantAdded   int
+		wantWorkers int32
+	}{
+		{name: "default count", wantCode: http.StatusOK, wantAdded: 1, wantWorkers: 2},
+		{name: "count", query: "?count=5", wantCode: http.StatusOK, wantAdded: 5, wantWorkers: 6},
+		{name: "zero count", query: "?count=0", wantCode: http.StatusBadRequest, wantWorkers: 1},
+		{name: "not a number", query: "?count=many", wantCode: http.StatusBadRequest, wantWorkers: 1},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, 1)
+			c.wgroup()
+
+			rec := call(c.addWorker(), http.MethodPost, "/worker/add"+tt.query, nil)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if got := atomic.LoadInt32(&c.workers); got != tt.wantWorkers {
+				t.Errorf("%d workers, want %d", got, tt.wantWorkers)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Added   int   `json:"added"`
+				Workers int32 `json:"workers"`
+			}
+			decode(t, rec, &got)
+			if got.Added != tt.wantAdded || got.Workers != tt.wantWorkers {
+				t.Errorf("response = %+v, want %d added and %d workers", got, tt.wantAdded, tt.wantWorkers)
+			}
+		})
+	}
+}
+
+func TestWithMaxWorkers(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 1, 2, withMaxWorkers(4))
+	c.wgroup()
+
+	steps := []struct {
+		query       string
+		wantCode    int
+		wantWorkers int32
+	}{
+		{query: "?count=2", wantCode: http.StatusOK, wantWorkers: 4},
+		{query: "", wantCode: http.StatusBadRequest, wantWorkers: 4},
+		{query: "?count=3", wantCode: http.StatusBadRequest, wantWorkers: 4},
+	}
+
+	for _, step := range steps {
+		rec := call(c.addWorker(), http.MethodPost, "/worker/add"+step.query, nil)
+		if rec.Code != step.wantCode {
+			t.Fatalf("add %q: status = %d, want %d: %s", step.query, rec.Code, step.wantCode, rec.Body)
+		}
+		if rec.Code == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "max pool size of 4") {
+			t.Errorf("add %q: rejection %q doesn't name the max pool size", step.query, rec.Body)
+		}
+		if got := atomic.LoadInt32(&c.workers); got != step.wantWorkers {
+			t.Errorf("add %q: %d workers, want %d", step.query, got, step.wantWorkers)
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	release := make(chan struct{})
+	work := func(ctx context.Context, job Job) error {
+		<-release
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), 5, 2, withRequestFunc(work))
+
+	health := func() (h struct {
+		Status     string `json:"status"`
+		Workers    int32  `json:"workers"`
+		QueueDepth int    `json:"queueDepth"`
+	}) {
+		rec := call(c.healthz(), http.MethodGet, "/healthz", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("healthz status = %d: %s", rec.Code, rec.Body)
+		}
+		decode(t, rec, &h)
+		return h
+	}
+
+	if got := health().Status; got != "stopped" {
+		t.Errorf("before starting status = %q, want stopped", got)
+	}
+
+	for i := 1; i <= 5; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+	waitFor(t, "the workers to pick up jobs", func() bool { return c.Stats().InFlight == 2 })
+	if h := health(); h.Status != "running" || h.Workers != 2 || h.QueueDepth != 3 {
+		t.Errorf("running health = %+v, want running with 2 workers and 3 queued", h)
+	}
+
+	drained, err := c.beginDrain()
+	if err != nil {
+		t.Fatalf("beginDrain() error = %v", err)
+	}
+	if got := health().Status; got != "draining" {
+		t.Errorf("while draining status = %q, want draining", got)
+	}
+
+	close(release)
+	<-drained
+	if h := health(); h.Status != "stopped" || h.Workers != 0 || h.QueueDepth != 0 {
+		t.Errorf("drained health = %+v, want stopped with no workers and an empty queue", h)
+	}
+}
+
+// logRecorder is a slog.Handler keeping the records it handles along with the attributes added by With
+type logRecorder struct {
+	mu      *sync.Mutex
+	records *[]slog.Record
+	attrs   []slog.Attr
+}
+
+func newLogRecorder() *logRecorder {
+	return &logRecorder{mu: &sync.Mutex{}, records: new([]slog.Record)}
+}
+
+func (h *logRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *logRecorder) Handle(_ context.Context, r slog.Record) error {
+	r = r.Clone()
+	r.AddAttrs(h.attrs...)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, r)
+
+	return nil
+}
+
+func (h *logRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logRecorder{mu: h.mu, records: h.records, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *logRecorder) WithGroup(string) slog.Handler { return h }
+
+// find returns the attributes of the first record logged with msg
+func (h *logRecorder) find(msg string) (map[string]slog.Value, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range *h.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := map[string]slog.Value{}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		return attrs, true
+	}
+
+	return nil, false
+}
+
+func TestStructuredLogging(t *testing.T) {
+	logs := newLogRecorder()
+	c := newTestController(t, newOKServer(t), 1, 1, withLogger(slog.New(logs)))
+	if err := c.submit(context.Background(), Job{ID: 7, Path: "/"}); err != nil {
+		t.Fatalf("submit() error = %v", err)
+	}
+	c.wgroup()
+	waitFor(t, "the job to be processed", func() bool { return c.Stats().Processed == 1 })
+
+	attrs, ok := logs.find("job completed")
+	if !ok {
+		t.Fatal("no job completed record")
+	}
+	want := map[string]int64{"worker_id": 1, "job_id": 7, "status": http.StatusOK}
+	for k, v := range want {
+		if got, ok := attrs[k]; !ok || got.Int64() != v {
+			t.Errorf("%s = %v, want %d", k, got, v)
+		}
+	}
+
+	call(c.stop(), http.MethodGet, "/stop", nil)
+	if attrs, ok := logs.find("worker pool stopped"); !ok || attrs["state"].String() != "stopped" {
+		t.Errorf("stop logged %v, want a state transition to stopped", attrs)
+	}
+}
+
+func TestWithRequestFunc(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = map[int]string{}
+	)
+	work := func(ctx context.Context, job Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		seen[job.ID] = job.URL
+		return nil
+	}
+
+	srv := newOKServer(t)
+	c := newTestController(t, srv, 10, 3, withRequestFunc(work))
+	want := map[int]string{}
+	for i := 1; i <= 10; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/items"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+		want[i] = srv.URL + "/items"
+	}
+	c.wgroup()
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == 10 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !maps.Equal(seen, want) {
+		t.Errorf("request func saw %v, want %v", seen, want)
+	}
+}
+
+func TestWithJobTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name       string
+		deadLetter bool
+	}{
+		{name: "counted as failed"},
+		{name: "dead lettered", deadLetter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 2, 1, withJobTimeout(50*time.Millisecond, tt.deadLetter))
+			for _, job := range []Job{{ID: 1, Path: "/slow"}, {ID: 2, Path: "/"}} {
+				if err := c.submit(context.Background(), job); err != nil {
+					t.Fatalf("submit() error = %v", err)
+				}
+			}
+
+			start := time.Now()
+			c.wgroup()
+			waitFor(t, "the worker to move on", func() bool { return c.Stats().Processed == 2 })
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("jobs took %v, want the slow job abandoned at the timeout", elapsed)
+			}
+
+			if res := <-c.results; !errors.Is(res.Err, context.DeadlineExceeded) {
+				t.Errorf("slow job error = %v, want %v", res.Err, context.DeadlineExceeded)
+			}
+			if s := c.Stats(); s.Failed != 1 {
+				t.Errorf("%d failed jobs, want 1", s.Failed)
+			}
+			if got := len(c.dead) == 1; got != tt.deadLetter {
+				t.Errorf("slow job dead lettered = %t, want %t", got, tt.deadLetter)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	release := make(chan struct{})
+	work := func(ctx context.Context, job Job) error {
+		if job.ID > 2 {
+			<-release
+		}
+		if job.ID%2 == 0 {
+			return errors.New("even job")
+		}
+		return nil
+	}
+
+	c := newTestController(t, newOKServer(t), 10, 2, withRequestFunc(work))
+	defer close(release)
+	for i := 1; i <= 6; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+	// jobs 1 and 2 complete, the workers then hold jobs 3 and 4 with 5 and 6 left queued
+	waitFor(t, "the pool to settle", func() bool { s := c.Stats(); return s.Processed == 2 && s.InFlight == 2 })
+
+	want := Stats{WorkerCount: 2, QueueDepth: 2, Processed: 2, Failed: 1, InFlight: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	var metrics Stats
+	decode(t, call(c.metrics(), http.MethodGet, "/metrics", nil), &metrics)
+	if metrics != want {
+		t.Errorf("/metrics = %+v, want %+v", metrics, want)
+	}
+
+	var workers struct {
+		Workers int32 `json:"workers"`
+	}
+	decode(t, call(c.workerCount(), http.MethodGet, "/worker/count", nil), &workers)
+	var depth struct {
+		Depth int `json:"depth"`
+	}
+	decode(t, call(c.queueDepth(), http.MethodGet, "/queue/depth", nil), &depth)
+	if workers.Workers != want.WorkerCount || depth.Depth != want.QueueDepth {
+		t.Errorf("endpoints report %d workers and a depth of %d, want %d and %d",
+			workers.Workers, depth.Depth, want.WorkerCount, want.QueueDepth)
+	}
+}
+
+// TestStatsConsistent takes snapshots while jobs complete, a snapshot never counts more failures than processed jobs
+func TestStatsConsistent(t *testing.T) {
+	const jobs = 200
+
+	c := newTestController(t, newOKServer(t), jobs, 4, withRequestFunc(func(ctx context.Context, job Job) error {
+		return errors.New("always fails")
+	}))
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(context.Background(), Job{ID: i}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		s := c.Stats()
+		if s.Failed != s.Processed {
+			t.Fatalf("snapshot %+v counts %d failures for %d processed jobs", s, s.Failed, s.Processed)
+		}
+		if s.Processed == jobs {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for the jobs to be processed")
+}
+
+// staleTransport fails every request with a connection error until its idle connections are closed
+type staleTransport struct {
+	stale  int32 // 1 while the connections are stale, must be accessed atomically
+	closes int32 // number of times the idle connections were closed, must be accessed atomically
+}
+
+func (s *staleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&s.stale) == 1 {
+		return nil, errors.New("connection reset by peer")
+	}
+
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func (s *staleTransport) CloseIdleConnections() {
+	atomic.AddInt32(&s.closes, 1)
+	atomic.StoreInt32(&s.stale, 0)
+}
+
+func TestWithIdleReset(t *testing.T) {
+	tests := []struct {
+		name       string
+		resetAfter int
+		wantFailed int64
+		wantCloses int32
+	}{
+		{name: "recovers after the threshold", resetAfter: 3, wantFailed: 3, wantCloses: 1},
+		{name: "disabled", resetAfter: 0, wantFailed: 6},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stale := &staleTransport{stale: 1}
+			c := newTestController(t, srv, 6, 1, withIdleReset(tt.resetAfter))
+			c.cl = patterns.NewClientWrapper(patterns.RoundTripper(stale))
+
+			for i := 1; i <= 6; i++ {
+				if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("submit() error = %v", err)
+				}
+			}
+			c.wgroup()
+			waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == 6 })
+
+			if s := c.Stats(); s.Failed != tt.wantFailed {
+				t.Errorf("%d failed jobs, want %d", s.Failed, tt.wantFailed)
+			}
+			if got := atomic.LoadInt32(&stale.closes); got != tt.wantCloses {
+				t.Errorf("idle connections closed %d times, want %d", got, tt.wantCloses)
+			}
+		})
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 2, 1)
+
+	// no worker is consuming, the third job finds the queue full
+	for i := 1; i <= 2; i++ {
+		if err := c.Enqueue(Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- c.Enqueue(Job{ID: 3, Path: "/"}) }()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrQueueFull) {
+			t.Errorf("Enqueue() to a full queue error = %v, want %v", err, ErrQueueFull)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Enqueue() blocked on a full queue")
+	}
+
+	c.closeQueue()
+	if err := c.Enqueue(Job{ID: 4, Path: "/"}); !errors.Is(err, errQueueClosed) {
+		t.Errorf("Enqueue() to a closed queue error = %v, want %v", err, errQueueClosed)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	hits := make(chan string, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- r.URL.Path
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantHit  string // path the worker requests, empty when the job is rejected
+	}{
+		{name: "url", body: `{"url": "` + upstream.URL + `/health"}`, wantCode: http.StatusAccepted, wantHit: "/health"},
+		{name: "path", body: `{"id": 9, "path": "/orders"}`, wantCode: http.StatusAccepted, wantHit: "/orders"},
+		{name: "malformed", body: `{"url":`, wantCode: http.StatusBadRequest},
+		{name: "relative url", body: `{"url": "/health"}`, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, upstream, 1, 1)
+			c.wgroup()
+
+			rec := call(c.ingest(), http.MethodPost, "/ingest", strings.NewReader(tt.body))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantHit == "" {
+				return
+			}
+
+			var got struct {
+				ID int `json:"id"`
+			}
+			decode(t, rec, &got)
+			if got.ID == 0 {
+				t.Error("no job id in the response")
+			}
+
+			select {
+			case path := <-hits:
+				if path != tt.wantHit {
+					t.Errorf("worker requested %s, want %s", path, tt.wantHit)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the ingested job never reached the upstream")
+			}
+		})
+	}
+}
+
+func TestIngestRejected(t *testing.T) {
+	// hold the jobs so the drain can't finish while the test ingests
+	release := make(chan struct{})
+	defer close(release)
+	c := newTestController(t, newOKServer(t), 1, 1, withRequestFunc(func(ctx context.Context, job Job) error {
+		<-release
+		return nil
+	}))
+	job := `{"path": "/"}`
+
+	// no worker is consuming, the second job finds the queue full
+	rec := call(c.ingest(), http.MethodPost, "/ingest", strings.NewReader(job))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	rec = call(c.ingest(), http.MethodPost, "/ingest", strings.NewReader(job))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("full queue status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	c.wgroup()
+	if _, err := c.beginDrain(); err != nil {
+		t.Fatalf("beginDrain() error = %v", err)
+	}
+	rec = call(c.ingest(), http.MethodPost, "/ingest", strings.NewReader(job))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("draining status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestWithStagger(t *testing.T) {
+	const (
+		workers = 5
+		stagger = 20 * time.Millisecond
+	)
+
+	c := newTestController(t, newOKServer(t), 1, workers, withStagger(stagger))
+
+	start := time.Now()
+	c.wgroup()
+	elapsed := time.Since(start)
+
+	// the first worker starts at once, each of the others waits up to the stagger
+	if max := (workers-1)*stagger + time.Second; elapsed > max {
+		t.Errorf("workers started over %v, want within %v", elapsed, max)
+	}
+	if got := atomic.LoadInt32(&c.workers); got != workers {
+		t.Errorf("%d workers running, want %d", got, workers)
+	}
+}
+
+// TestStaggerStop checks a stop halts a staggered start instead of waiting out the stagger
+func TestStaggerStop(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 1, 5, withStagger(time.Hour))
+
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		c.wgroup()
+	}()
+	waitFor(t, "the first worker", func() bool { return atomic.LoadInt32(&c.workers) == 1 })
+	waitFor(t, "the pool to run", func() bool { return c.state() == "running" })
+
+	call(c.stop(), http.MethodGet, "/stop", nil)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("staggered start still waiting after stop")
+	}
+	waitFor(t, "the workers to stop", func() bool { return atomic.LoadInt32(&c.workers) == 0 })
+}
+
+func TestWithTarget(t *testing.T) {
+	hits := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- r.URL.Path
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name   string
+		target string
+		path   string
+		want   string
+	}{
+		{name: "host", target: srv.URL, path: "/health", want: "/health"},
+		{name: "base path", target: srv.URL + "/api/v1", path: "/health", want: "/api/v1/health"},
+		{name: "trailing slash", target: srv.URL + "/api/", path: "health", want: "/api/health"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, srv, 1, 1, withTarget(tt.target))
+			if err := c.submit(context.Background(), Job{ID: 1, Path: tt.path}); err != nil {
+				t.Fatalf("submit() error = %v", err)
+			}
+			c.wgroup()
+
+			select {
+			case got := <-hits:
+				if got != tt.want {
+					t.Errorf("target got %s, want %s", got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the job never reached the target")
+			}
+		})
+	}
+}
+
+// TestCloseQueueWhileProducing stops and restarts the pool mid production and then closes the queue under producers
+// still sending, no send may panic on the closed queue
+func TestCloseQueueWhileProducing(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 5, 2)
+	c.wgroup()
+
+	var (
+		wg       sync.WaitGroup
+		accepted int64
+	)
+	errs := make(chan error, 4)
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; ; i++ {
+				err := c.submit(context.Background(), Job{ID: i, Path: "/"})
+				if err != nil {
+					errs <- err
+					return
+				}
+				atomic.AddInt64(&accepted, 1)
+			}
+		}()
+	}
+
+	waitFor(t, "production to start", func() bool { return atomic.LoadInt64(&accepted) > 10 })
+	call(c.stop(), http.MethodGet, "/stop", nil)
+	call(c.start(), http.MethodGet, "/start", nil)
+	waitFor(t, "production to resume", func() bool { return atomic.LoadInt64(&accepted) > 20 })
+
+	c.closeQueue()
+	c.closeQueue() // closing twice is a no-op
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if !errors.Is(err, errQueueClosed) {
+			t.Errorf("submit() error = %v, want %v", err, errQueueClosed)
+		}
+	}
+
+	// the workers finish off the queue and exit once it is closed
+	waitFor(t, "the workers to exit", func() bool { return atomic.LoadInt32(&c.workers) == 0 })
+	if got := c.Stats().Processed; got != atomic.LoadInt64(&accepted) {
+		t.Errorf("%d jobs processed, want all %d accepted", got, accepted)
+	}
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		queued       int // jobs already in the queue of 5
+		batch        int
+		wantAccepted int
+		wantErr      error
+	}{
+		{name: "fits", batch: 3, wantAccepted: 3},
+		{name: "fills exactly", queued: 2, batch: 3, wantAccepted: 3},
+		{name: "partially accepted", queued: 2, batch: 6, wantAccepted: 3, wantErr: ErrQueueFull},
+		{name: "full queue", queued: 5, batch: 2, wantErr: ErrQueueFull},
+		{name: "empty batch"},
+	}
+
+	srv := newOKServer(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no worker is consuming, the queue only fills up
+			c := newTestController(t, srv, 5, 1)
+			for i := 0; i < tt.queued; i++ {
+				if err := c.Enqueue(Job{ID: i + 1, Path: "/"}); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+			}
+
+			jobs := make([]Job, tt.batch)
+			for i := range jobs {
+				jobs[i] = Job{ID: 100 + i, Path: "/"}
+			}
+			accepted, err := c.EnqueueBatch(jobs)
+			if accepted != tt.wantAccepted || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnqueueBatch() = %d, %v, want %d, %v", accepted, err, tt.wantAccepted, tt.wantErr)
+			}
+
+			// the accepted jobs are the front of the batch, in order
+			for i := 0; i < tt.queued; i++ {
+				<-c.queue
+			}
+			for i := 0; i < accepted; i++ {
+				if job := <-c.queue; job.ID != jobs[i].ID {
+					t.Errorf("queued job %d, want %d", job.ID, jobs[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestWithClientFactory(t *testing.T) {
+	const (
+		workers = 3
+		jobs    = 30
+	)
+
+	var open int64 // connections the upstream holds open
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond) // long enough for every worker to pick up jobs
+	}))
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt64(&open, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt64(&open, -1)
+		}
+	}
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+
+	// each client records the local address of every connection it sends a request over
+	var (
+		mu    sync.Mutex
+		conns []map[string]bool // connections used, by client
+	)
+	factory := func() *patterns.ClientWrapper {
+		mu.Lock()
+		used := make(map[string]bool)
+		conns = append(conns, used)
+		mu.Unlock()
+
+		record := func(req *http.Request) *http.Request {
+			trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+				mu.Lock()
+				used[info.Conn.LocalAddr().String()] = true
+				mu.Unlock()
+			}}
+			return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		}
+
+		return patterns.NewClientWrapper(patterns.Transport(patterns.NewTransportWrapper()), patterns.RequestHook(record))
+	}
+
+	c := newTestController(t, upstream, jobs, workers, withClientFactory(factory))
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	c.wgroup()
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == jobs })
+
+	mu.Lock()
+	if len(conns) != workers {
+		t.Errorf("factory called %d times, want once per worker (%d)", len(conns), workers)
+	}
+	owner := make(map[string]int) // client that used each connection
+	for i, used := range conns {
+		if len(used) == 0 {
+			t.Errorf("client %d sent no requests", i)
+		}
+		for addr := range used {
+			if j, ok := owner[addr]; ok {
+				t.Errorf("connection %s shared by clients %d and %d", addr, j, i)
+			}
+			owner[addr] = i
+		}
+	}
+	mu.Unlock()
+
+	// the workers' idle connections are closed once they stop
+	drained, err := c.beginDrain()
+	if err != nil {
+		t.Fatalf("beginDrain() error = %v", err)
+	}
+	<-drained
+	waitFor(t, "the worker connections to close", func() bool { return atomic.LoadInt64(&open) == 0 })
+}
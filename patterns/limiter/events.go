@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jobEvent is a job completion streamed to the subscribers of the events endpoint
+type jobEvent struct {
+	JobID    int     `json:"id"`
+	Status   int     `json:"status"`   // http status code of the response, zero if no response was received
+	Duration float64 `json:"duration"` // seconds taken to process the job
+	Error    string  `json:"error,omitempty"`
+}
+
+// eventBroker fans job events out to the connected subscribers.  Publishing never blocks a worker, a subscriber too
+// slow to keep up misses the events that don't fit in its buffer.
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[chan jobEvent]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: make(map[chan jobEvent]struct{})}
+}
+
+// subscribe registers a new subscriber, the returned func removes it
+func (b *eventBroker) subscribe() (<-chan jobEvent, func()) {
+	ch := make(chan jobEvent, 64)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// publish sends ev to every subscriber with room in its buffer
+func (b *eventBroker) publish(ev jobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// events streams job completions to the client as Server-Sent Events until the client disconnects or the controller
+// shuts down, a live view of the pool without polling the metrics
+func (c *controller) events() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events, unsubscribe := c.broker.subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-c.ctx.Done():
+				return
+			case ev := <-events:
+				data, err := json.Marshal(ev)
+				if err != nil {
+					c.logger.Error("encoding job event", "job_id", ev.JobID, "error", err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: job\ndata: %s\n\n", data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// publishEvent streams the outcome of a job to the events subscribers
+func (c *controller) publishEvent(job Job, status int, duration time.Duration, err error) {
+	ev := jobEvent{JobID: job.ID, Status: status, Duration: duration.Seconds()}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	c.broker.publish(ev)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	c := newTestController(t, upstream, 10, 1)
+	srv := httptest.NewServer(c.events())
+	t.Cleanup(srv.Close)
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	t.Cleanup(disconnect)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("connecting to the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// the headers are flushed once the subscriber is registered, the jobs can't complete before it
+	c.wgroup()
+	tests := []struct {
+		job        Job
+		wantStatus int
+	}{
+		{job: Job{ID: 1, Path: "/"}, wantStatus: http.StatusOK},
+		{job: Job{ID: 2, Path: "/missing"}, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if err := c.submit(context.Background(), tt.job); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			events <- sc.Text()
+		}
+	}()
+
+	for _, tt := range tests {
+		var event, data string
+		for event == "" || data == "" {
+			select {
+			case line, ok := <-events:
+				if !ok {
+					t.Fatal("the event stream ended")
+				}
+				if name, ok := strings.CutPrefix(line, "event: "); ok {
+					event = name
+				}
+				if payload, ok := strings.CutPrefix(line, "data: "); ok {
+					data = payload
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the event of job %d", tt.job.ID)
+			}
+		}
+
+		var got jobEvent
+		if err := json.Unmarshal([]byte(data), &got); err != nil {
+			t.Fatalf("decoding event %q: %v", data, err)
+		}
+		if event != "job" || got.JobID != tt.job.ID || got.Status != tt.wantStatus || got.Duration <= 0 {
+			t.Errorf("event %s %+v, want a job event for job %d with status %d", event, got, tt.job.ID, tt.wantStatus)
+		}
+	}
+
+	// a disconnected client is no longer subscribed
+	disconnect()
+	waitFor(t, "the subscriber to be removed", func() bool {
+		c.broker.mu.Lock()
+		defer c.broker.mu.Unlock()
+
+		return len(c.broker.subs) == 0
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// snowflakeEpoch is the start of the timestamps in snowflake ids
+var snowflakeEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// snowflakeIDs returns a job id generator producing snowflake style ids, a millisecond timestamp followed by the node
+// and a sequence number within the millisecond.  The ids stay unique across restarts and, as long as every instance
+// has its own node between 0 and 1023, across instances.  The ids need a 64 bit int.  The generator is safe for
+// concurrent use.
+func snowflakeIDs(node int) func() int {
+	var (
+		mu   sync.Mutex
+		last int64 // millisecond of the last id
+		seq  int64 // ids handed out within the last millisecond
+	)
+
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Since(snowflakeEpoch).Milliseconds()
+		if now <= last {
+			// same millisecond, or the clock went backwards, keep counting from the last millisecond
+			now = last
+			seq++
+			if seq == 1<<12 {
+				// the sequence is exhausted, borrow the next millisecond
+				now++
+				seq = 0
+			}
+		} else {
+			seq = 0
+		}
+		last = now
+
+		return int(now<<22 | int64(node&0x3ff)<<12 | seq)
+	}
+}
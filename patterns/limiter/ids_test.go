@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWithJobIDGenerator(t *testing.T) {
+	var n int64
+	gen := func() int { return int(1000 + atomic.AddInt64(&n, 1)) }
+	c := newTestController(t, newOKServer(t), 10, 1, withJobIDGenerator(gen))
+
+	tests := []struct {
+		name   string
+		body   string
+		wantID int
+	}{
+		{name: "generated", body: `{"path": "/a"}`, wantID: 1001},
+		{name: "generated again", body: `{"path": "/b"}`, wantID: 1002},
+		{name: "carried", body: `{"id": 7, "path": "/c"}`, wantID: 7},
+		{name: "generated after a carried id", body: `{"path": "/d"}`, wantID: 1003},
+	}
+
+	// no worker is consuming, the ingested jobs stay in the queue
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := call(c.ingest(), http.MethodPost, "/ingest", strings.NewReader(tt.body))
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+			}
+
+			var got struct {
+				ID int `json:"id"`
+			}
+			decode(t, rec, &got)
+			if got.ID != tt.wantID {
+				t.Errorf("response id = %d, want %d", got.ID, tt.wantID)
+			}
+			if job := <-c.queue; job.ID != tt.wantID {
+				t.Errorf("queued job id = %d, want %d", job.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestSnowflakeIDs(t *testing.T) {
+	const (
+		producers = 8
+		perFeed   = 5000 // more than one millisecond's worth of sequence numbers in total
+	)
+
+	tests := []struct {
+		name string
+		node int
+	}{
+		{name: "node 0", node: 0},
+		{name: "node 1023", node: 1023},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := snowflakeIDs(tt.node)
+
+			var (
+				mu   sync.Mutex
+				seen = make(map[int]bool, producers*perFeed)
+				wg   sync.WaitGroup
+			)
+			for i := 0; i < producers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					ids := make([]int, perFeed)
+					for j := range ids {
+						ids[j] = gen()
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+					for j, id := range ids {
+						if j > 0 && id <= ids[j-1] {
+							t.Errorf("id %d handed out after %d", id, ids[j-1])
+						}
+						if seen[id] {
+							t.Errorf("id %d handed out twice", id)
+						}
+						seen[id] = true
+						if node := id >> 12 & 0x3ff; node != tt.node {
+							t.Errorf("id %d carries node %d, want %d", id, node, tt.node)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
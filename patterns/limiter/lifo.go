@@ -0,0 +1,19 @@
+package main
+
+import (
+	"examples/patterns"
+)
+
+// newestFirst orders the jobs of the LIFO controller newest first.  Processing the newest jobs first keeps the latency
+// of fresh requests low under load at the expense of older ones, which suits latency sensitive workloads where a stale
+// response is worth little.
+func newestFirst(a, b queuedJob) bool {
+	return a.seq > b.seq
+}
+
+// newLIFOController initializes a controller that processes the newest job first with a worker pool of workers
+// workers, the channel backed controller remains the first in first out default.  The job stack is unbounded, so
+// Enqueue never sheds load with ErrQueueFull.
+func newLIFOController(workers int, cl *patterns.ClientWrapper, opts ...controllerOption) *controller {
+	return newOrderedController(newOrderedQueue(newestFirst), workers, cl, opts...)
+}
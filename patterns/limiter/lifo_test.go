@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"examples/patterns"
+)
+
+func TestProcessingOrder(t *testing.T) {
+	// the channel backed controller under the signature of the ordered ones, it is the first in first out default
+	fifo := func(workers int, cl *patterns.ClientWrapper, opts ...controllerOption) *controller {
+		return newController(10, workers, cl, opts...)
+	}
+
+	tests := []struct {
+		name    string
+		newCtrl func(int, *patterns.ClientWrapper, ...controllerOption) *controller
+		want    []int
+	}{
+		{name: "fifo", newCtrl: fifo, want: []int{1, 2, 3, 4, 5}},
+		{name: "lifo", newCtrl: newLIFOController, want: []int{1, 5, 4, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := processInOrder(t, tt.newCtrl, []Job{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}, {ID: 5}})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("processed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// pause holds the workers idle without stopping them, consumption from the work queue halts once the jobs in flight
+// complete and picks up again on resume without the cost of respawning the pool.  Pausing a pool that isn't running or
+// is already paused responds with a conflict.
+func (c *controller) pause() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		if !c.running || c.draining || c.paused {
+			c.mu.Unlock()
+			http.Error(w, "worker pool is not running or is already paused", http.StatusConflict)
+			return
+		}
+		c.paused = true
+		c.unpaused = make(chan struct{})
+		close(c.pausing) // wake the workers waiting on the queue so they park
+		c.mu.Unlock()
+
+		c.logger.Info("worker pool paused", "state", "paused")
+	}
+}
+
+// resume restarts consumption from the work queue by a paused pool, resuming a pool that isn't paused responds with a
+// conflict
+func (c *controller) resume() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		if !c.paused {
+			c.mu.Unlock()
+			http.Error(w, "worker pool is not paused", http.StatusConflict)
+			return
+		}
+		c.resumeLocked()
+		c.mu.Unlock()
+
+		c.logger.Info("worker pool resumed", "state", "running")
+	}
+}
+
+// resumeLocked releases the paused workers, c.mu must be held
+func (c *controller) resumeLocked() {
+	if !c.paused {
+		return
+	}
+
+	c.paused = false
+	c.pausing = make(chan struct{})
+	close(c.unpaused)
+}
+
+// awaitResume parks a worker while the pool is paused.  It returns the channel that is closed when the pool is next
+// paused, so idle workers can park, and false if the worker must exit instead, once done is closed or when the worker
+// picks up a remove signal while parked.
+func (c *controller) awaitResume(logger *slog.Logger, done <-chan struct{}) (<-chan struct{}, bool) {
+	for {
+		c.mu.Lock()
+		paused, pausing, unpaused := c.paused, c.pausing, c.unpaused
+		c.mu.Unlock()
+
+		// stop clears the pause as it stops the pool, don't let a worker it woke pick up another job
+		select {
+		case <-done:
+			return nil, false
+		default:
+		}
+
+		if !paused {
+			return pausing, true
+		}
+
+		select {
+		case <-done:
+			return nil, false
+		case <-unpaused:
+		case <-c.remove:
+			c.removed(logger)
+			return nil, false
+		}
+	}
+}
+
+// closedChan returns a closed channel
+func closedChan() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+
+	return ch
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// control calls a control endpoint handler and returns the response status
+func control(h http.HandlerFunc, path string) int {
+	return call(h, http.MethodGet, path, nil).Code
+}
+
+func TestPauseResume(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 2)
+	c.wgroup()
+
+	if code := control(c.pause(), "/pause"); code != http.StatusOK {
+		t.Fatalf("pause status = %d, want %d", code, http.StatusOK)
+	}
+	if code := control(c.pause(), "/pause"); code != http.StatusConflict {
+		t.Errorf("second pause status = %d, want %d", code, http.StatusConflict)
+	}
+	if got := c.state(); got != "paused" {
+		t.Errorf("state = %q, want paused", got)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := c.Stats(); got.Processed != 0 || got.QueueDepth != 3 {
+		t.Fatalf("paused pool processed %d with %d queued, want 0 with 3 queued", got.Processed, got.QueueDepth)
+	}
+
+	if code := control(c.resume(), "/resume"); code != http.StatusOK {
+		t.Fatalf("resume status = %d, want %d", code, http.StatusOK)
+	}
+	if code := control(c.resume(), "/resume"); code != http.StatusConflict {
+		t.Errorf("second resume status = %d, want %d", code, http.StatusConflict)
+	}
+
+	waitFor(t, "the queued jobs", func() bool { return c.Stats().Processed == 3 })
+}
+
+func TestRemoveWorkerWhilePaused(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 2)
+	c.wgroup()
+
+	if code := control(c.pause(), "/pause"); code != http.StatusOK {
+		t.Fatalf("pause status = %d, want %d", code, http.StatusOK)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.signalRemove(ctx, 1); err != nil {
+		t.Fatalf("signalRemove() error = %v", err)
+	}
+
+	waitFor(t, "the parked worker to exit", func() bool { return atomic.LoadInt32(&c.workers) == 1 })
+
+	// the remaining worker picks up where the pool left off on resume
+	control(c.resume(), "/resume")
+	if err := c.submit(context.Background(), Job{ID: 1, Path: "/"}); err != nil {
+		t.Fatalf("submit() error = %v", err)
+	}
+	waitFor(t, "the job", func() bool { return c.Stats().Processed == 1 })
+}
+
+func TestStopWhilePaused(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 2)
+	c.wgroup()
+
+	control(c.pause(), "/pause")
+	if code := control(c.stop(), "/stop"); code != http.StatusOK {
+		t.Fatalf("stop status = %d, want %d", code, http.StatusOK)
+	}
+
+	waitFor(t, "the parked workers to stop", func() bool { return atomic.LoadInt32(&c.workers) == 0 })
+	if got := c.state(); got != "stopped" {
+		t.Errorf("state = %q, want stopped", got)
+	}
+}
+
+func TestAutoscaleWhilePaused(t *testing.T) {
+	tests := []struct {
+		name   string
+		queued int
+	}{
+		{name: "empty queue"},
+		{name: "backlog", queued: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, newOKServer(t), 10, 2, withAutoscaling(1, 4, 1, 5*time.Millisecond))
+			c.wgroup()
+			control(c.pause(), "/pause")
+
+			for i := 1; i <= tt.queued; i++ {
+				if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("submit() error = %v", err)
+				}
+			}
+
+			go c.autoscale()
+			time.Sleep(20 * c.scale.interval)
+
+			if got := atomic.LoadInt32(&c.workers); got != 2 {
+				t.Errorf("workers = %d while paused, want 2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// JobStore persists the jobs waiting in the work queue so they survive a restart.  Jobs are appended as they are
+// queued, and the store is rewritten with just the jobs not yet processed on graceful shutdown and whenever the records
+// of processed jobs make up most of the store, so the store doesn't grow without bound.  Jobs in flight that the
+// shutdown cuts short count as not yet processed.  After a crash the jobs processed since the last rewrite are
+// processed again, delivery is at least once.  Job contexts are not persisted.
+type JobStore interface {
+	Append(job Job) error     // records a queued job
+	Replace(jobs []Job) error // replaces the stored jobs with jobs
+	Load() ([]Job, error)     // returns the stored jobs in the order they were queued
+}
+
+// compactAfter is the fewest records of processed jobs the job store is compacted for, so a store with few jobs isn't
+// rewritten after every job
+const compactAfter = 64
+
+// pendingJobs tracks the stored jobs that haven't been processed yet so the job store can be compacted to just those
+type pendingJobs struct {
+	mu    sync.Mutex     // also held while writing to the store so the store never misses a pending job
+	seq   uint64         // last sequence number handed out
+	jobs  map[uint64]Job // pending jobs by sequence number
+	stale int            // records in the store of jobs since processed or never queued
+}
+
+// sorted returns the pending jobs in the order they were queued, p.mu must be held
+func (p *pendingJobs) sorted() []Job {
+	seqs := make([]uint64, 0, len(p.jobs))
+	for seq := range p.jobs {
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+
+	jobs := make([]Job, len(seqs))
+	for i, seq := range seqs {
+		jobs[i] = p.jobs[seq]
+	}
+
+	return jobs
+}
+
+// withJobStore persists queued jobs to store, use restore to requeue the stored jobs on startup
+func withJobStore(store JobStore) controllerOption {
+	return func(c *controller) {
+		c.store = store
+		c.pending = &pendingJobs{jobs: make(map[uint64]Job)}
+	}
+}
+
+// persist appends a job about to be queued to the job store and tracks it as pending, it returns the job tagged with
+// the sequence number it is tracked by.  A job that can't be appended stays queued, it is only stored by the next
+// rewrite of the store.
+func (c *controller) persist(job Job) Job {
+	if c.store == nil {
+		return job
+	}
+
+	c.pending.mu.Lock()
+	defer c.pending.mu.Unlock()
+
+	job = c.trackLocked(job)
+	if err := c.store.Append(job); err != nil {
+		c.logger.Error("failed to persist job", "job_id", job.ID, "error", err)
+	}
+
+	return job
+}
+
+// trackLocked tags a stored job with the next sequence number and tracks it as pending, c.pending.mu must be held
+func (c *controller) trackLocked(job Job) Job {
+	c.pending.seq++
+	job.seq = c.pending.seq
+	c.pending.jobs[job.seq] = job
+
+	return job
+}
+
+// release stops tracking a stored job once it has been processed, or when it couldn't be queued after all.  The store
+// is compacted once the records of released jobs reach compactAfter and outnumber the pending jobs.
+func (c *controller) release(job Job) {
+	if c.store == nil || job.seq == 0 {
+		return
+	}
+
+	c.pending.mu.Lock()
+	defer c.pending.mu.Unlock()
+
+	if _, ok := c.pending.jobs[job.seq]; !ok {
+		return
+	}
+	delete(c.pending.jobs, job.seq)
+	c.pending.stale++
+
+	if c.pending.stale < compactAfter || c.pending.stale < len(c.pending.jobs) {
+		return
+	}
+
+	jobs := c.pending.sorted()
+	if err := c.store.Replace(jobs); err != nil {
+		// the stale records stay counted so the next release tries again
+		c.logger.Error("failed to compact the job store", "jobs", len(jobs), "error", err)
+		return
+	}
+	c.pending.stale = 0
+}
+
+// restore requeues the jobs in the job store, blocking until they are all queued or the root context is done.  It
+// returns the number of jobs requeued.  The jobs are already stored so they aren't appended again, the jobs that
+// couldn't be requeued stay stored.
+func (c *controller) restore() (int, error) {
+	if c.store == nil {
+		return 0, nil
+	}
+
+	jobs, err := c.store.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	c.pending.mu.Lock()
+	for i, job := range jobs {
+		jobs[i] = c.trackLocked(job)
+	}
+	c.pending.mu.Unlock()
+
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	for i, job := range jobs {
+		if err := c.accepting(); err != nil {
+			return i, err
+		}
+
+		if err := c.send(c.ctx, job, true); err != nil {
+			return i, err
+		}
+		c.checkDepth()
+	}
+
+	c.logger.Info("restored jobs from the job store", "jobs", len(jobs))
+
+	return len(jobs), nil
+}
+
+// flush rewrites the job store with the jobs not yet processed, the jobs left in the work queue, the workers must have
+// stopped
+func (c *controller) flush() {
+	if c.store == nil {
+		return
+	}
+
+	c.pending.mu.Lock()
+	defer c.pending.mu.Unlock()
+
+	jobs := c.pending.sorted()
+	if err := c.store.Replace(jobs); err != nil {
+		c.logger.Error("failed to flush the work queue to the job store", "jobs", len(jobs), "error", err)
+		return
+	}
+	c.pending.stale = 0
+
+	c.logger.Info("flushed the work queue to the job store", "jobs", len(jobs))
+}
+
+// fileStore is a JobStore keeping jobs in a file, one JSON encoded job per line
+type fileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// storedJob is the persisted form of a Job
+type storedJob struct {
+	ID       int    `json:"id"`
+	URL      string `json:"url,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+}
+
+// newFileStore creates a job store backed by the file at path, the file is created on the first append
+func newFileStore(path string) *fileStore {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Append(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(toStored(job)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Replace writes the jobs to a temporary file renamed over the store so a crash never leaves a partial store behind
+func (s *fileStore) Replace(jobs []Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, job := range jobs {
+		if err := enc.Encode(toStored(job)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s *fileStore) Load() ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var jobs []Job
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var sj storedJob
+		if err := dec.Decode(&sj); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, Job{ID: sj.ID, URL: sj.URL, Path: sj.Path, Priority: sj.Priority, Weight: sj.Weight})
+	}
+
+	return jobs, nil
+}
+
+func toStored(job Job) storedJob {
+	return storedJob{ID: job.ID, URL: job.URL, Path: job.Path, Priority: job.Priority, Weight: job.Weight}
+}
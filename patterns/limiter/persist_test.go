@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// storedIDs returns the ids of the jobs in store in order
+func storedIDs(t *testing.T, store JobStore) []int {
+	t.Helper()
+
+	jobs, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	ids := make([]int, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+
+	return ids
+}
+
+func TestFileStore(t *testing.T) {
+	store := newFileStore(filepath.Join(t.TempDir(), "jobs.jsonl"))
+
+	if ids := storedIDs(t, store); len(ids) != 0 {
+		t.Fatalf("new store holds %v, want no jobs", ids)
+	}
+
+	want := Job{ID: 1, URL: "http://example.com/a", Path: "/b", Priority: 2, Weight: 3}
+	if err := store.Append(want); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err := store.Append(Job{ID: 2}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	jobs, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs[0] != want || jobs[1].ID != 2 {
+		t.Fatalf("Load() = %+v, want %+v and job 2", jobs, want)
+	}
+
+	if err := store.Replace([]Job{{ID: 3}}); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	if ids := storedIDs(t, store); !slices.Equal(ids, []int{3}) {
+		t.Errorf("after Replace() the store holds %v, want [3]", ids)
+	}
+}
+
+// TestRestart queues jobs with one of them in flight, shuts down, and checks a controller built on the same store
+// requeues and processes them
+func TestRestart(t *testing.T) {
+	store := newFileStore(filepath.Join(t.TempDir(), "jobs.jsonl"))
+	srv := newOKServer(t)
+
+	// the first job is in flight at shutdown, it runs until the shutdown cancels it
+	started := make(chan struct{})
+	inFlight := func(ctx context.Context, job Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	root, shutdown := context.WithCancel(context.Background())
+	first := newTestController(t, srv, 10, 1, withContext(root), withJobStore(store), withRequestFunc(inFlight))
+	for i := 1; i <= 3; i++ {
+		if err := first.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	first.wgroup()
+	<-started
+	shutdown()
+	first.limit.Wait()
+	first.flush()
+
+	if ids := storedIDs(t, store); !slices.Equal(ids, []int{1, 2, 3}) {
+		t.Fatalf("store holds %v after shutdown, want [1 2 3]", ids)
+	}
+
+	second := newTestController(t, srv, 10, 1, withJobStore(store))
+	n, err := second.restore()
+	if err != nil || n != 3 {
+		t.Fatalf("restore() = %d, %v, want 3, nil", n, err)
+	}
+	if got := second.Stats().QueueDepth; got != 3 {
+		t.Errorf("queue depth after restore = %d, want 3", got)
+	}
+	if ids := storedIDs(t, store); !slices.Equal(ids, []int{1, 2, 3}) {
+		t.Errorf("store holds %v after restore, want the jobs stored once", ids)
+	}
+
+	second.wgroup()
+	waitFor(t, "the restored jobs", func() bool { return second.Stats().Processed == 3 })
+
+	second.flush()
+	if ids := storedIDs(t, store); len(ids) != 0 {
+		t.Errorf("store holds %v once every job is processed, want no jobs", ids)
+	}
+}
+
+// TestStoreCompaction checks the store is rewritten while the controller runs so it doesn't grow without bound
+func TestStoreCompaction(t *testing.T) {
+	const jobs = 10 * compactAfter
+
+	store := newFileStore(filepath.Join(t.TempDir(), "jobs.jsonl"))
+	c := newTestController(t, newOKServer(t), 10, 2, withJobStore(store))
+	c.wgroup()
+
+	for i := 1; i <= jobs; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == jobs })
+
+	// every compaction leaves the pending jobs and the records appended since
+	if ids := storedIDs(t, store); len(ids) > 2*compactAfter {
+		t.Errorf("store holds %d records after processing %d jobs, want at most %d", len(ids), jobs, 2*compactAfter)
+	}
+}
+
+func TestEnqueueFullNotStored(t *testing.T) {
+	store := newFileStore(filepath.Join(t.TempDir(), "jobs.jsonl"))
+	c := newTestController(t, newOKServer(t), 1, 1, withJobStore(store))
+
+	if err := c.Enqueue(Job{ID: 1, Path: "/"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := c.Enqueue(Job{ID: 2, Path: "/"}); err != ErrQueueFull {
+		t.Fatalf("Enqueue() on a full queue error = %v, want %v", err, ErrQueueFull)
+	}
+
+	c.flush()
+	if ids := storedIDs(t, store); !slices.Equal(ids, []int{1}) {
+		t.Errorf("store holds %v, want only the queued job", ids)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"examples/patterns"
+)
+
+// byPriority orders the jobs of the priority controller by priority, highest first, jobs of equal priority are handed
+// out first in first out
+func byPriority(a, b queuedJob) bool {
+	if a.job.Priority != b.job.Priority {
+		return a.job.Priority > b.job.Priority
+	}
+
+	return a.seq < b.seq
+}
+
+// newPriorityController initializes a controller backed by a priority queue with a worker pool of workers workers,
+// workers always pick up the highest priority job available.  The priority queue is unbounded, so Enqueue never sheds
+// load with ErrQueueFull.
+func newPriorityController(workers int, cl *patterns.ClientWrapper, opts ...controllerOption) *controller {
+	return newOrderedController(newOrderedQueue(byPriority), workers, cl, opts...)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"examples/patterns"
+)
+
+// orderRecorder is a request func recording the order jobs are processed in.  The first job holds up its worker until
+// release is called so the jobs queued meanwhile contend for the worker.
+type orderRecorder struct {
+	mu      sync.Mutex
+	ids     []int
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func newOrderRecorder() *orderRecorder {
+	return &orderRecorder{started: make(chan struct{}), gate: make(chan struct{})}
+}
+
+func (r *orderRecorder) work(ctx context.Context, job Job) error {
+	r.mu.Lock()
+	r.ids = append(r.ids, job.ID)
+	first := len(r.ids) == 1
+	r.mu.Unlock()
+
+	if first {
+		close(r.started)
+		<-r.gate
+	}
+
+	return nil
+}
+
+func (r *orderRecorder) order() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]int(nil), r.ids...)
+}
+
+// newTestOrderedController creates a controller with an ordered queue using newCtrl and a discarded log, the
+// controller's root context is cancelled when the test ends
+func newTestOrderedController(t *testing.T,
+	newCtrl func(int, *patterns.ClientWrapper, ...controllerOption) *controller, workers int,
+	opts ...controllerOption) *controller {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	srv := newOKServer(t)
+	opts = append([]controllerOption{
+		withContext(ctx),
+		withLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		withTarget(srv.URL),
+	}, opts...)
+
+	return newCtrl(workers, patterns.NewClientWrapper(), opts...)
+}
+
+// processInOrder queues the first job, waits for the single worker to pick it up, queues the rest while the worker is
+// busy, and returns the order the jobs were processed in
+func processInOrder(t *testing.T, newCtrl func(int, *patterns.ClientWrapper, ...controllerOption) *controller,
+	jobs []Job) []int {
+	t.Helper()
+
+	rec := newOrderRecorder()
+	c := newTestOrderedController(t, newCtrl, 1, withRequestFunc(rec.work))
+	c.wgroup()
+
+	if err := c.submit(context.Background(), jobs[0]); err != nil {
+		t.Fatalf("submit() error = %v", err)
+	}
+	select {
+	case <-rec.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first job")
+	}
+
+	for _, job := range jobs[1:] {
+		if err := c.submit(context.Background(), job); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	// let the dispatcher settle on the next job before the worker is free
+	time.Sleep(20 * time.Millisecond)
+	close(rec.gate)
+
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == int64(len(jobs)) })
+
+	return rec.order()
+}
+
+func TestPriorityOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []Job
+		want []int
+	}{
+		{
+			name: "highest priority first",
+			jobs: []Job{{ID: 1}, {ID: 2, Priority: 1}, {ID: 3, Priority: 5}, {ID: 4}, {ID: 5, Priority: 3}},
+			want: []int{1, 3, 5, 2, 4},
+		},
+		{
+			name: "equal priority first in first out",
+			jobs: []Job{{ID: 1}, {ID: 2, Priority: 2}, {ID: 3, Priority: 2}, {ID: 4, Priority: 2}},
+			want: []int{1, 2, 3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := processInOrder(t, newPriorityController, tt.jobs)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("processed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOrderedController checks a controller with an ordered queue supports the features of the channel controller
+func TestOrderedController(t *testing.T) {
+	tests := []struct {
+		name    string
+		newCtrl func(int, *patterns.ClientWrapper, ...controllerOption) *controller
+	}{
+		{name: "priority", newCtrl: newPriorityController},
+		{name: "lifo", newCtrl: newLIFOController},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" enqueue", func(t *testing.T) {
+			c := newTestOrderedController(t, tt.newCtrl, 2)
+
+			if err := c.Enqueue(Job{ID: 1, Path: "/"}); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+			if n, err := c.EnqueueBatch([]Job{{ID: 2, Path: "/"}, {ID: 3, Path: "/"}}); n != 2 || err != nil {
+				t.Fatalf("EnqueueBatch() = %d, %v, want 2, nil", n, err)
+			}
+
+			rec := httptest.NewRecorder()
+			c.ingest()(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"id": 4, "path": "/"}`)))
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("ingest status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+			}
+
+			if got := c.Stats().QueueDepth; got != 4 {
+				t.Errorf("queue depth = %d, want 4", got)
+			}
+
+			c.wgroup()
+			waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == 4 })
+			if got := c.Stats(); got.QueueDepth != 0 || got.Failed != 0 {
+				t.Errorf("stats = %+v, want an empty queue and no failures", got)
+			}
+		})
+
+		t.Run(tt.name+" remove worker", func(t *testing.T) {
+			c := newTestOrderedController(t, tt.newCtrl, 2)
+			c.wgroup()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := c.signalRemove(ctx, 1); err != nil {
+				t.Fatalf("signalRemove() error = %v", err)
+			}
+			waitFor(t, "the worker to exit", func() bool { return atomic.LoadInt32(&c.workers) == 1 })
+		})
+
+		t.Run(tt.name+" max jobs", func(t *testing.T) {
+			c := newTestOrderedController(t, tt.newCtrl, 2, withMaxJobs(2))
+			for i := 1; i <= 3; i++ {
+				if err := c.Enqueue(Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+			}
+			c.wgroup()
+
+			select {
+			case <-c.finished:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the job limit")
+			}
+			if got := c.Stats(); got.Processed != 2 || got.QueueDepth != 1 {
+				t.Errorf("processed %d with %d queued, want 2 with 1 queued", got.Processed, got.QueueDepth)
+			}
+		})
+
+		t.Run(tt.name+" drain", func(t *testing.T) {
+			c := newTestOrderedController(t, tt.newCtrl, 2)
+			for i := 1; i <= 5; i++ {
+				if err := c.Enqueue(Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+			}
+			c.wgroup()
+
+			drained, err := c.beginDrain()
+			if err != nil {
+				t.Fatalf("beginDrain() error = %v", err)
+			}
+			select {
+			case <-drained:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the drain")
+			}
+			if got := c.Stats(); got.Processed != 5 || got.WorkerCount != 0 {
+				t.Errorf("drained with %d processed and %d workers, want 5 and 0", got.Processed, got.WorkerCount)
+			}
+		})
+
+		t.Run(tt.name+" close queue", func(t *testing.T) {
+			c := newTestOrderedController(t, tt.newCtrl, 2)
+			for i := 1; i <= 3; i++ {
+				if err := c.Enqueue(Job{ID: i, Path: "/"}); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+			}
+			c.closeQueue()
+			if err := c.Enqueue(Job{ID: 4, Path: "/"}); err != errQueueClosed {
+				t.Errorf("Enqueue() on a closed queue error = %v, want %v", err, errQueueClosed)
+			}
+
+			c.wgroup()
+			waitFor(t, "the workers to finish the queue", func() bool { return atomic.LoadInt32(&c.workers) == 0 })
+			if got := c.Stats().Processed; got != 3 {
+				t.Errorf("processed %d, want 3", got)
+			}
+		})
+	}
+}
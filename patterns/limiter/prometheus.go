@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registerMetrics creates the prometheus collectors for the controller.  The collectors are registered on a registry
+// owned by the controller rather than the default registry so multiple controllers, such as in tests, don't clash.
+func (c *controller) registerMetrics() {
+	c.registry = prometheus.NewRegistry()
+
+	c.latency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "limiter_request_duration_seconds",
+		Help:    "Latency of requests issued by the worker pool.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	c.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "limiter_workers",
+			Help: "Number of live workers in the worker pool.",
+		}, func() float64 {
+			return float64(atomic.LoadInt32(&c.workers))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "limiter_queue_depth",
+			Help: "Number of jobs waiting in the work queue.",
+		}, func() float64 {
+			return float64(c.depth())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "limiter_jobs_processed_total",
+			Help: "Number of jobs processed, successful or not.",
+		}, func() float64 {
+			return float64(atomic.LoadInt64(&c.processed))
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "limiter_jobs_failed_total",
+			Help: "Number of jobs that failed.",
+		}, func() float64 {
+			return float64(atomic.LoadInt64(&c.failed))
+		}),
+		c.latency,
+	)
+}
+
+// prometheusMetrics exposes the controller's collectors in the prometheus exposition format
+func (c *controller) prometheusMetrics() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{})
+}
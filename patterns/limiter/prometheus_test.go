@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the controller's metrics in the prometheus exposition format
+func scrape(t *testing.T, c *controller) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	c.prometheusMetrics().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	return rec.Body.String()
+}
+
+func TestMetricsReset(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 1, withRequestFunc(func(ctx context.Context, job Job) error {
+		if job.ID%2 == 0 {
+			return errors.New("even job")
+		}
+		return nil
+	}))
+	c.wgroup()
+
+	submit := func(ids ...int) {
+		t.Helper()
+		for _, id := range ids {
+			if err := c.submit(context.Background(), Job{ID: id, Path: "/"}); err != nil {
+				t.Fatalf("submit() error = %v", err)
+			}
+		}
+	}
+
+	submit(1, 2, 3)
+	waitFor(t, "the first jobs", func() bool { return c.Stats().Processed == 3 })
+
+	rec := httptest.NewRecorder()
+	c.metricsReset()(rec, httptest.NewRequest(http.MethodGet, "/metrics/reset", nil))
+	var reset Stats
+	if err := json.NewDecoder(rec.Body).Decode(&reset); err != nil {
+		t.Fatal(err)
+	}
+	if reset.Processed != 3 || reset.Failed != 1 {
+		t.Errorf("reset snapshot processed %d failed %d, want 3 and 1", reset.Processed, reset.Failed)
+	}
+	if s := c.Stats(); s.Processed != 0 || s.Failed != 0 {
+		t.Errorf("after reset processed %d failed %d, want 0 and 0", s.Processed, s.Failed)
+	}
+
+	submit(4, 5)
+	waitFor(t, "the next jobs", func() bool { return c.Stats().Processed == 2 })
+
+	if s := c.Stats(); s.Failed != 1 {
+		t.Errorf("failed since the reset = %d, want 1", s.Failed)
+	}
+
+	// the prometheus counters are totals and never go down
+	metrics := scrape(t, c)
+	for _, want := range []string{"limiter_jobs_processed_total 5", "limiter_jobs_failed_total 2"} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	c := newTestController(t, newOKServer(t), 10, 2)
+	c.wgroup()
+	for i := 1; i <= 3; i++ {
+		if err := c.submit(context.Background(), Job{ID: i, Path: "/"}); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	waitFor(t, "the jobs to be processed", func() bool { return c.Stats().Processed == 3 })
+
+	metrics := scrape(t, c)
+	for _, want := range []string{
+		"limiter_workers 2",
+		"limiter_queue_depth 0",
+		"limiter_jobs_processed_total 3",
+		"limiter_jobs_failed_total 0",
+		"limiter_request_duration_seconds_count 3",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+
+	// every controller owns its registry, a second one registers the same names without clashing
+	other := newTestController(t, newOKServer(t), 1, 1)
+	if !strings.Contains(scrape(t, other), "limiter_jobs_processed_total 0") {
+		t.Error("a second controller shares the counters of the first")
+	}
+}
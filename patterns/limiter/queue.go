@@ -0,0 +1,200 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+
+	"examples/patterns"
+)
+
+// errQueueClosed is returned when submitting a job to a closed queue
+var errQueueClosed = errors.New("queue is closed")
+
+// queuedJob is a job waiting in an ordered queue, seq records the order jobs were pushed in
+type queuedJob struct {
+	job Job
+	seq uint64
+}
+
+// jobHeap implements heap.Interface ordering jobs by less, the job less reports first is handed out first
+type jobHeap struct {
+	jobs []queuedJob
+	less func(a, b queuedJob) bool
+}
+
+func (h *jobHeap) Len() int { return len(h.jobs) }
+
+func (h *jobHeap) Less(i, j int) bool { return h.less(h.jobs[i], h.jobs[j]) }
+
+func (h *jobHeap) Swap(i, j int) { h.jobs[i], h.jobs[j] = h.jobs[j], h.jobs[i] }
+
+func (h *jobHeap) Push(x any) { h.jobs = append(h.jobs, x.(queuedJob)) }
+
+func (h *jobHeap) Pop() any {
+	n := len(h.jobs)
+	x := h.jobs[n-1]
+	h.jobs[n-1] = queuedJob{} // drop the reference to the job's context
+	h.jobs = h.jobs[:n-1]
+
+	return x
+}
+
+// orderedQueue is an unbounded job queue handing out jobs in an order of its own, such as by priority, for orders a
+// channel can't provide.  Channels can't be sorted, so the jobs are kept in a mutex protected heap and the controller's
+// dispatch hands them to the workers through the work queue one at a time, the workers consume the work queue like
+// that of any other controller.
+type orderedQueue struct {
+	mu     sync.Mutex
+	jobs   jobHeap
+	seq    uint64
+	held   bool // true while dispatch holds a job taken off the heap, the job still counts as queued
+	closed bool
+
+	pushed chan struct{} // signaled on every push and on close to wake dispatch
+}
+
+func newOrderedQueue(less func(a, b queuedJob) bool) *orderedQueue {
+	return &orderedQueue{
+		jobs:   jobHeap{less: less},
+		pushed: make(chan struct{}, 1),
+	}
+}
+
+// push adds a job to the queue, failing with errQueueClosed once the queue is closed
+func (q *orderedQueue) push(job Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	q.seq++
+	heap.Push(&q.jobs, queuedJob{job: job, seq: q.seq})
+	q.signal()
+
+	return nil
+}
+
+// pop takes the next job off the heap without blocking, it returns false when the heap is empty.  The job is held until
+// it is handed out with sent or given back with requeue.
+func (q *orderedQueue) pop() (queuedJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.jobs.Len() == 0 {
+		return queuedJob{}, false
+	}
+	q.held = true
+
+	return heap.Pop(&q.jobs).(queuedJob), true
+}
+
+// sent releases the held job once it has been handed to a worker
+func (q *orderedQueue) sent() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.held = false
+}
+
+// requeue gives the held job back to the heap, it keeps its place in the order
+func (q *orderedQueue) requeue(qj queuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.held = false
+	heap.Push(&q.jobs, qj)
+}
+
+// len returns the number of jobs waiting in the queue, including the held job
+func (q *orderedQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.held {
+		return q.jobs.Len() + 1
+	}
+
+	return q.jobs.Len()
+}
+
+// finished reports whether the queue is closed and every job has been handed out
+func (q *orderedQueue) finished() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed && q.jobs.Len() == 0 && !q.held
+}
+
+// close stops the queue from accepting jobs, the jobs already queued are still handed out
+func (q *orderedQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.signal()
+}
+
+// signal wakes dispatch without blocking, a signal already pending covers this one
+func (q *orderedQueue) signal() {
+	select {
+	case q.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// newOrderedController initializes a controller handing out jobs in the order of q with a worker pool of workers
+// workers.  The work queue is unbuffered so the next job is only picked once a worker is ready for it.
+func newOrderedController(q *orderedQueue, workers int, cl *patterns.ClientWrapper,
+	opts ...controllerOption) *controller {
+	c := newController(0, workers, cl, opts...)
+	c.ordered = q
+
+	go c.dispatch()
+
+	return c
+}
+
+// dispatch feeds the work queue from the ordered queue until the root context is done, then gives the job it holds
+// back to the ordered queue.  Once the ordered queue is closed and every job has been handed out it closes the work
+// queue and returns, the workers then terminate as they would on a closed channel.
+func (c *controller) dispatch() {
+	q := c.ordered
+	for {
+		qj, ok := q.pop()
+		if !ok {
+			if q.finished() {
+				close(c.queue)
+				return
+			}
+
+			select {
+			case <-q.pushed:
+				continue
+			case <-c.ctx.Done():
+				return
+			}
+		}
+
+		// a job pushed while this one was being popped may go first
+		select {
+		case <-q.pushed:
+			q.requeue(qj)
+			continue
+		default:
+		}
+
+		select {
+		case c.queue <- qj.job:
+			q.sent()
+		case <-q.pushed:
+			// a job pushed while waiting for a worker may go ahead of the held job, pick again
+			q.requeue(qj)
+		case <-c.ctx.Done():
+			q.requeue(qj)
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package patterns
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// WithLogger logs the method, URL, status, and duration of every request once it completes, failed requests are logged
+// with the error instead of a status.  The request and response bodies are left untouched.  Each line carries the
+// request ID set with WithRequestID, or a generated one for requests without.
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req, id := withRequestID(req)
+				start := time.Now()
+				resp, err := next.RoundTrip(req)
+				duration := time.Since(start)
+
+				if err != nil {
+					logger.ErrorContext(req.Context(), "request failed",
+						"request_id", id,
+						"method", req.Method,
+						"url", req.URL.String(),
+						"duration", duration,
+						"error", err,
+					)
+					return nil, err
+				}
+
+				logger.InfoContext(req.Context(), "request completed",
+					"request_id", id,
+					"method", req.Method,
+					"url", req.URL.String(),
+					"status", resp.StatusCode,
+					"duration", duration,
+				)
+
+				return resp, nil
+			})
+		})
+	}
+}
+
+// WithBodyLogging logs the request and response bodies of every request, up to maxBytes of each body is logged and
+// longer bodies are marked as truncated.  Bodies that aren't valid UTF-8 are logged base64 encoded.  The logged bytes are
+// stitched back onto the front of the body so downstream reads still see the whole body.  The request and response
+// lines carry the same request ID, see WithLogger.
+func WithBodyLogging(logger *slog.Logger, maxBytes int) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req, id := withRequestID(req)
+				if req.Body != nil && req.Body != http.NoBody {
+					body, attrs, err := peekBody(req.Body, maxBytes)
+					if err != nil {
+						req.Body.Close()
+						return nil, err
+					}
+
+					req = req.Clone(req.Context())
+					req.Body = body
+					logger.InfoContext(req.Context(), "request body",
+						append([]any{"request_id", id, "method", req.Method, "url", req.URL.String()}, attrs...)...)
+				}
+
+				resp, err := next.RoundTrip(req)
+				if err != nil {
+					return nil, err
+				}
+
+				body, attrs, err := peekBody(resp.Body, maxBytes)
+				if err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
+				resp.Body = body
+				logger.InfoContext(req.Context(), "response body",
+					append([]any{"request_id", id, "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode}, attrs...)...)
+
+				return resp, nil
+			})
+		})
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id, requests made with the context are logged with it so log lines can be
+// correlated across services
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// withRequestID returns the request ID carried by the context of req.  A request without one is given a random UUID,
+// the returned copy of req carries it so every logger further down the chain logs the same ID.
+func withRequestID(req *http.Request) (*http.Request, string) {
+	if id, ok := req.Context().Value(requestIDKey{}).(string); ok && id != "" {
+		return req, id
+	}
+
+	id := newRequestID()
+
+	return req.WithContext(WithRequestID(req.Context(), id)), id
+}
+
+// newRequestID returns a random UUID
+func newRequestID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// peekBody reads up to maxBytes from body for logging and returns a body that replays the bytes read followed by the
+// rest of the original body, along with the log attributes describing the bytes read
+func peekBody(body io.ReadCloser, maxBytes int) (io.ReadCloser, []any, error) {
+	// read one byte past the cap to tell whether the body was truncated
+	prefix, err := io.ReadAll(io.LimitReader(body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logged := prefix
+	truncated := len(prefix) > maxBytes
+	if truncated {
+		logged = prefix[:maxBytes]
+	}
+
+	attrs := []any{"truncated", truncated}
+	if utf8.Valid(logged) {
+		attrs = append(attrs, "body", string(logged))
+	} else {
+		attrs = append(attrs, "body", base64.StdEncoding.EncodeToString(logged), "encoding", "base64")
+	}
+
+	return readCloser{
+		Reader: io.MultiReader(bytes.NewReader(prefix), body),
+		Closer: body,
+	}, attrs, nil
+}
+
+// readCloser combines a reader with the closer of the body it replaces
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
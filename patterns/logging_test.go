@@ -0,0 +1,185 @@
+package patterns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logLines decodes the JSON log lines written to buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("decoding log line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestLoggingRequestID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string // expected request ID, empty for a generated one
+	}{
+		{name: "generated", ctx: context.Background()},
+		{name: "from context", ctx: WithRequestID(context.Background(), "req-42"), want: "req-42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+			cl := NewClientWrapper(WithLogger(logger), WithBodyLogging(logger, 64))
+
+			req, err := http.NewRequestWithContext(tt.ctx, http.MethodPost, srv.URL, strings.NewReader("ping"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := cl.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			lines := logLines(t, &buf)
+			if len(lines) != 3 {
+				t.Fatalf("got %d log lines, want request body, response body, and completion", len(lines))
+			}
+
+			id, _ := lines[0]["request_id"].(string)
+			if id == "" || (tt.want != "" && id != tt.want) {
+				t.Fatalf("request_id %q, want %q", id, tt.want)
+			}
+			for _, line := range lines[1:] {
+				if line["request_id"] != id {
+					t.Errorf("%s logged request_id %v, want %s", line["msg"], line["request_id"], id)
+				}
+			}
+		})
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(srv.Close)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantMsg string
+	}{
+		{name: "completed", url: srv.URL + "/echo", wantMsg: "request completed"},
+		{name: "failed", url: closed.URL + "/echo", wantMsg: "request failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cl := NewClientWrapper(WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+			resp, err := cl.Post(context.Background(), tt.url, "text/plain", strings.NewReader("ping"))
+			if err == nil {
+				// the logger must leave the body for the caller
+				if b, _ := io.ReadAll(resp.Body); string(b) != "ping" {
+					t.Errorf("body = %q, want ping", b)
+				}
+				resp.Body.Close()
+			}
+
+			lines := logLines(t, &buf)
+			if len(lines) != 1 {
+				t.Fatalf("got %d log lines, want 1", len(lines))
+			}
+			line := lines[0]
+			if line["msg"] != tt.wantMsg || line["method"] != http.MethodPost || line["url"] != tt.url {
+				t.Errorf("logged %v, want %q for POST %s", line, tt.wantMsg, tt.url)
+			}
+			if _, ok := line["duration"]; !ok {
+				t.Error("no duration logged")
+			}
+			if err == nil && line["status"] != float64(http.StatusOK) {
+				t.Errorf("status = %v, want %d", line["status"], http.StatusOK)
+			}
+			if err != nil && line["error"] == nil {
+				t.Error("no error logged for a failed request")
+			}
+		})
+	}
+}
+
+func TestWithBodyLogging(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name          string
+		body          string
+		wantLogged    string
+		wantTruncated bool
+		wantEncoding  any // nil for a body logged as text
+	}{
+		{name: "under the cap", body: "hello", wantLogged: "hello"},
+		{name: "truncated", body: "hello world", wantLogged: "hello wo", wantTruncated: true},
+		{
+			name:          "binary",
+			body:          "\xff\xfe\x00\x01binary",
+			wantLogged:    base64.StdEncoding.EncodeToString([]byte("\xff\xfe\x00\x01bina")),
+			wantTruncated: true,
+			wantEncoding:  "base64",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cl := NewClientWrapper(WithBodyLogging(slog.New(slog.NewJSONHandler(&buf, nil)), 8))
+
+			resp, err := cl.Post(context.Background(), srv.URL, "application/octet-stream", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != tt.body {
+				t.Errorf("response body = %q, want the whole echoed body %q", b, tt.body)
+			}
+
+			lines := logLines(t, &buf)
+			if len(lines) != 2 {
+				t.Fatalf("got %d log lines, want the request and response bodies", len(lines))
+			}
+			for _, line := range lines {
+				if line["body"] != tt.wantLogged || line["truncated"] != tt.wantTruncated || line["encoding"] != tt.wantEncoding {
+					t.Errorf("%s logged body %v truncated %v encoding %v, want %q, %t, %v", line["msg"],
+						line["body"], line["truncated"], line["encoding"], tt.wantLogged, tt.wantTruncated, tt.wantEncoding)
+				}
+			}
+		})
+	}
+}
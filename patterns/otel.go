@@ -0,0 +1,58 @@
+package patterns
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// propagator injects the W3C trace context and baggage headers into outgoing requests
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// WithOTel traces every request with a client span started from tracer, nil for a no-op tracer.  The span is a child of
+// any span in the request context and is propagated to the server in W3C traceparent headers.  The span records the
+// method, URL, and response status, and is marked as failed for transport errors and 4xx and 5xx responses.  The span
+// ends once the response headers are received.
+func WithOTel(tracer trace.Tracer) ClientOption {
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("")
+	}
+
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method,
+					trace.WithSpanKind(trace.SpanKindClient),
+					trace.WithAttributes(
+						attribute.String("http.request.method", req.Method),
+						attribute.String("url.full", req.URL.Redacted()),
+						attribute.String("server.address", req.URL.Hostname()),
+					),
+				)
+				defer span.End()
+
+				req = req.Clone(ctx)
+				propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+				resp, err := next.RoundTrip(req)
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+					return nil, err
+				}
+
+				span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+				if resp.StatusCode >= http.StatusBadRequest {
+					span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", resp.StatusCode))
+				}
+
+				return resp, nil
+			})
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package patterns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithOTel(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantCode   codes.Code
+	}{
+		{name: "ok", path: "/", wantStatus: http.StatusOK, wantCode: codes.Unset},
+		{name: "client error", path: "/missing", wantStatus: http.StatusNotFound, wantCode: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+			cl := NewClientWrapper(WithOTel(provider.Tracer("test")))
+			get(t, cl, srv.URL+tt.path)
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("recorded %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.SpanKind != trace.SpanKindClient || span.Name != "HTTP GET" {
+				t.Errorf("span %q of kind %v, want a client span named %q", span.Name, span.SpanKind, "HTTP GET")
+			}
+			if span.Status.Code != tt.wantCode {
+				t.Errorf("span status = %v, want %v", span.Status.Code, tt.wantCode)
+			}
+
+			attrs := attribute.NewSet(span.Attributes...)
+			for key, want := range map[attribute.Key]attribute.Value{
+				"http.request.method":       attribute.StringValue(http.MethodGet),
+				"url.full":                  attribute.StringValue(srv.URL + tt.path),
+				"server.address":            attribute.StringValue("127.0.0.1"),
+				"http.response.status_code": attribute.IntValue(tt.wantStatus),
+			} {
+				if got, ok := attrs.Value(key); !ok || got != want {
+					t.Errorf("attribute %s = %v, want %v", key, got.Emit(), want.Emit())
+				}
+			}
+
+			// the server receives the span's context
+			want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+			if traceparent != want {
+				t.Errorf("traceparent = %q, want %q", traceparent, want)
+			}
+		})
+	}
+
+	t.Run("parent span", func(t *testing.T) {
+		exporter := tracetest.NewInMemoryExporter()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+		ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+		cl := NewClientWrapper(WithOTel(provider.Tracer("test")))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		resp, err := cl.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		resp.Body.Close()
+		parent.End()
+
+		spans := exporter.GetSpans()
+		if len(spans) != 2 || spans[0].Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("request span isn't a child of the span in the request context: %+v", spans)
+		}
+	})
+
+	t.Run("transport error", func(t *testing.T) {
+		exporter := tracetest.NewInMemoryExporter()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+		refused := errors.New("connection refused")
+		fail := RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, refused
+		}))
+		cl := NewClientWrapper(fail, WithOTel(provider.Tracer("test")))
+		if _, err := cl.Get(context.Background(), "http://upstream.invalid/"); !errors.Is(err, refused) {
+			t.Fatalf("Get() error = %v, want %v", err, refused)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 || spans[0].Status.Code != codes.Error || len(spans[0].Events) == 0 {
+			t.Errorf("transport error not recorded on the span: %+v", spans)
+		}
+	})
+
+	t.Run("no-op tracer", func(t *testing.T) {
+		cl := NewClientWrapper(WithOTel(nil))
+		get(t, cl, srv.URL)
+		if traceparent != "" {
+			t.Errorf("no-op tracer propagated traceparent %q", traceparent)
+		}
+	})
+}
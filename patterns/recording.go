@@ -0,0 +1,61 @@
+package patterns
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RecordingTransport is an http.RoundTripper that records every request it sees, for asserting on the calls made by
+// code using a ClientWrapper.  Requests are passed on to Next, or answered with an empty 200 OK when Next is nil so no
+// network is needed.  Install it with the RoundTripper client option, it is safe for concurrent use.
+type RecordingTransport struct {
+	Next http.RoundTripper
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+// NewRecordingTransport creates a RecordingTransport passing requests on to next, nil to answer them with stubs
+func NewRecordingTransport(next http.RoundTripper) *RecordingTransport {
+	return &RecordingTransport{Next: next}
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// record a copy so later changes to the request's headers don't rewrite history, the body is left for the
+	// transport to read
+	recorded := req.Clone(req.Context())
+	recorded.Body = nil
+
+	t.mu.Lock()
+	t.requests = append(t.requests, recorded)
+	t.mu.Unlock()
+
+	if t.Next != nil {
+		return t.Next.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// Requests returns the requests recorded so far in the order they were sent, the bodies are not recorded
+func (t *RecordingTransport) Requests() []*http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]*http.Request(nil), t.requests...)
+}
@@ -0,0 +1,58 @@
+package patterns
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	srv, rr := newRecordingServer(t)
+
+	tests := []struct {
+		name string
+		next http.RoundTripper
+		base string
+	}{
+		{name: "stubbed", base: "http://upstream.invalid"},
+		{name: "passed on", next: http.DefaultTransport, base: srv.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := NewRecordingTransport(tt.next)
+			cl := NewClientWrapper(RoundTripper(rec), UserAgent("recorder"))
+
+			get(t, cl, tt.base+"/a")
+			resp, err := cl.Post(context.Background(), tt.base+"/b", "application/json", strings.NewReader(`{}`))
+			if err != nil {
+				t.Fatalf("Post() error = %v", err)
+			}
+			resp.Body.Close()
+
+			want := []struct{ method, url, contentType string }{
+				{http.MethodGet, tt.base + "/a", ""},
+				{http.MethodPost, tt.base + "/b", "application/json"},
+			}
+			got := rec.Requests()
+			if len(got) != len(want) {
+				t.Fatalf("recorded %d requests, want %d", len(got), len(want))
+			}
+			for i, w := range want {
+				r := got[i]
+				if r.Method != w.method || r.URL.String() != w.url || r.Header.Get("Content-Type") != w.contentType {
+					t.Errorf("request %d = %s %s %q, want %s %s %q", i, r.Method, r.URL, r.Header.Get("Content-Type"),
+						w.method, w.url, w.contentType)
+				}
+				if r.UserAgent() != "recorder" {
+					t.Errorf("request %d recorded without the headers set by the client options", i)
+				}
+			}
+
+			if tt.next != nil && rr.request().URL.Path != "/b" {
+				t.Errorf("server last got %s, want the requests passed on", rr.request().URL.Path)
+			}
+		})
+	}
+}
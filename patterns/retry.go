@@ -0,0 +1,104 @@
+package patterns
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Retry retries idempotent requests that fail with a connection error or a 502, 503, or 504 response.  maxAttempts is
+// the total number of attempts including the first, the delay between attempts starts at baseDelay and doubles each
+// attempt with jitter applied.  Requests with a body are only retried when GetBody is set so the body can be replayed.
+// When all attempts are exhausted the last response or error is returned.
+func Retry(maxAttempts int, baseDelay time.Duration) ClientOption {
+	return func(c *ClientWrapper) {
+		c.wrappers = append(c.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return &retryTransport{
+				next:        next,
+				maxAttempts: maxAttempts,
+				baseDelay:   baseDelay,
+			}
+		})
+	}
+}
+
+type retryTransport struct {
+	next        http.RoundTripper
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !replayable(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	delay := t.baseDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.maxAttempts || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		// drain and close the body so the connection can be reused by the next attempt
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(jitter(delay))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// replayable reports whether req can safely be sent more than once
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryable reports whether the outcome of an attempt is worth retrying
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// the caller gave up, retrying would only fail again
+		return req.Context().Err() == nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// jitter returns a random duration in the range [d/2, d) to keep retrying clients from synchronizing
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
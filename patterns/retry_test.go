@@ -0,0 +1,104 @@
+package patterns
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		statuses []int // status of each attempt, the last one repeats
+		want     int   // status returned to the caller
+		attempts int
+	}{
+		{name: "succeeds first", method: http.MethodGet, statuses: []int{200}, want: 200, attempts: 1},
+		{name: "retries 503", method: http.MethodGet, statuses: []int{503, 502, 200}, want: 200, attempts: 3},
+		{name: "exhausted", method: http.MethodGet, statuses: []int{504}, want: 504, attempts: 3},
+		{name: "500 not retried", method: http.MethodGet, statuses: []int{500, 200}, want: 500, attempts: 1},
+		{name: "POST not retried", method: http.MethodPost, body: "x", statuses: []int{503, 200}, want: 503, attempts: 1},
+		{
+			name:     "PUT body replayed",
+			method:   http.MethodPut,
+			body:     "payload",
+			statuses: []int{503, 200},
+			want:     200,
+			attempts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				bodies []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+
+				mu.Lock()
+				bodies = append(bodies, string(b))
+				n := len(bodies)
+				mu.Unlock()
+
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses))-1])
+			}))
+			t.Cleanup(srv.Close)
+
+			cl := NewClientWrapper(Retry(3, time.Millisecond))
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, _ := http.NewRequestWithContext(context.Background(), tt.method, srv.URL, body)
+			resp, err := cl.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if len(bodies) != tt.attempts {
+				t.Errorf("%d attempts, want %d", len(bodies), tt.attempts)
+			}
+			for i, b := range bodies {
+				if b != tt.body {
+					t.Errorf("attempt %d sent body %q, want %q", i+1, b, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryContextCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	cl := NewClientWrapper(Retry(5, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := cl.Get(ctx, srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Get() succeeded, want the context error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Get() returned after %v, want it to stop waiting when the context ends", elapsed)
+	}
+}
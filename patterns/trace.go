@@ -0,0 +1,37 @@
+package patterns
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+)
+
+// WithConnReuseTracing logs whether each request got a reused connection from the idle pool or a new one, for
+// debugging keep-alive and pooling behavior.  Lines are logged at debug level with the remote address and, for reused
+// connections, how long the connection sat idle.  Tracing is only installed when the transport is used through the
+// Transport client option.
+func WithConnReuseTracing(logger *slog.Logger) TransportOption {
+	return func(t *TransportWrapper) {
+		t.wrappers = append(t.wrappers, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				ctx := req.Context()
+				trace := &httptrace.ClientTrace{
+					GotConn: func(info httptrace.GotConnInfo) {
+						attrs := []any{
+							"method", req.Method,
+							"url", req.URL.String(),
+							"remote_addr", info.Conn.RemoteAddr().String(),
+							"reused", info.Reused,
+						}
+						if info.WasIdle {
+							attrs = append(attrs, "idle_time", info.IdleTime)
+						}
+						logger.DebugContext(ctx, "got connection", attrs...)
+					},
+				}
+
+				return next.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+			})
+		})
+	}
+}
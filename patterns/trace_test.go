@@ -0,0 +1,37 @@
+package patterns
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithConnReuseTracing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	cl := NewClientWrapper(Transport(NewTransportWrapper(WithConnReuseTracing(logger))))
+	t.Cleanup(cl.CloseIdleConnections)
+
+	get(t, cl, srv.URL)
+	get(t, cl, srv.URL)
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want one per request", len(lines))
+	}
+
+	for i, want := range []bool{false, true} {
+		line := lines[i]
+		if line["msg"] != "got connection" || line["reused"] != want || line["remote_addr"] != srv.Listener.Addr().String() {
+			t.Errorf("request %d logged %v, want reused %t", i+1, line, want)
+		}
+		if _, idle := line["idle_time"]; idle != want {
+			t.Errorf("request %d logged idle time %t, want %t", i+1, idle, want)
+		}
+	}
+}